@@ -1,21 +1,29 @@
 package main
 
 import (
+	"flag"
 	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
 	"github.com/myoperator/clusterconfigoperator/pkg/controller"
+	"github.com/myoperator/clusterconfigoperator/pkg/health"
 	"github.com/myoperator/clusterconfigoperator/pkg/k8sconfig"
+	"github.com/myoperator/clusterconfigoperator/pkg/options"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	_ "k8s.io/code-generator"
 	"k8s.io/klog/v2"
 	"os"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	ctrlcontroller "sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/manager/signals"
 	"sigs.k8s.io/controller-runtime/pkg/source"
-	"time"
 )
 
 /*
@@ -26,45 +34,139 @@ import (
 */
 
 func main() {
+	opts := options.NewOptions()
+	opts.AddFlags(flag.CommandLine)
+	err := opts.Parse(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		klog.Error(err, "invalid options")
+		os.Exit(1)
+	}
+
+	// 日志级别与格式，klog 的输出也统一交给 zap
+	level, _ := opts.Log.ZapLevel()
+	encoder := zap.JSONEncoder()
+	if opts.Log.Format == "console" {
+		encoder = zap.ConsoleEncoder()
+	}
+	logger := zap.New(zap.Level(level), encoder)
+	logf.SetLogger(logger)
+	klog.SetLogger(logger)
+
+	// 客户端限流，并发同步大量 namespace 时避免被默认的 5 QPS 限制
+	restConfig, err := k8sconfig.K8sRestConfig(opts.Client)
+	if err != nil {
+		klog.Error(err, "unable to load kubeconfig")
+		os.Exit(1)
+	}
+
+	// 1. ++ 注册进入序列化表，cache 需要在创建管理器时识别 ClusterConfig
+	err = clusterconfigv1alpha1.SchemeBuilder.AddToScheme(scheme.Scheme)
+	if err != nil {
+		klog.Error(err, "unable add schema")
+		os.Exit(1)
+	}
 
-	logf.SetLogger(zap.New())
-	var d time.Duration = 0
-	// 1. 管理器初始化
-	mgr, err := manager.New(k8sconfig.K8sRestConfig(), manager.Options{
+	// 2. 管理器初始化
+	// cache 中只保留由 ClusterConfig 管理的 ConfigMap Secret，避免集群中大量 Secret 占用内存
+	managedCopies := cache.ObjectSelector{Label: controller.ManagedCopySelector()}
+	selectors := cache.SelectorsByObject{
+		&v1.ConfigMap{}: managedCopies,
+		&v1.Secret{}:    managedCopies,
+	}
+	// 只处理指定 namespace 下的 ClusterConfig，下发的资源对象仍然可以在所有 namespace 中
+	if opts.WatchNamespace != "" {
+		selectors[&clusterconfigv1alpha1.ClusterConfig{}] = cache.ObjectSelector{Field: fields.OneTermEqualSelector("metadata.namespace", opts.WatchNamespace)}
+	}
+	syncPeriod := opts.SyncPeriod.Duration // 为 0 时 resync不设置触发
+	mgr, err := manager.New(restConfig, manager.Options{
+		Scheme:     scheme.Scheme,
 		Logger:     logf.Log.WithName("clusterconfig-operator"),
-		SyncPeriod: &d, // resync不设置触发
+		SyncPeriod: &syncPeriod,
+		// 等待正在进行的调协结束的时间，超时后 Start 返回错误
+		GracefulShutdownTimeout: &opts.GracefulShutdownTimeout.Duration,
+		MetricsBindAddress:      opts.MetricsBindAddress,
+		HealthProbeBindAddress:  opts.HealthProbeBindAddress,
+		// 多副本部署时通过 Lease 选主，只有 leader 调协；
+		// 退出时主动释放 Lease，其他副本无需等待 Lease 过期即可接管
+		LeaderElection:                opts.LeaderElection.LeaderElect,
+		LeaderElectionID:              opts.LeaderElection.ResourceName,
+		LeaderElectionNamespace:       opts.LeaderElection.ResourceNamespace,
+		LeaderElectionResourceLock:    resourcelock.LeasesResourceLock,
+		LeaderElectionReleaseOnCancel: true,
+		NewCache:                      cache.BuilderWithOptions(cache.Options{SelectorsByObject: selectors}),
 	})
 	if err != nil {
-		mgr.GetLogger().Error(err, "unable to set up manager")
+		klog.Error(err, "unable to set up manager")
 		os.Exit(1)
 	}
 
-	// 2. ++ 注册进入序列化表
-	err = clusterconfigv1alpha1.SchemeBuilder.AddToScheme(mgr.GetScheme())
+	// /healthz 只检查进程存活；/readyz 检查 cache 同步以及 apiserver 是否可达。
+	// 不检查是否为 leader：Deployment 滚动更新时需要新副本就绪后才会停止旧的 leader，
+	// 否则新副本永远无法就绪，滚动更新会一直卡住。leader 状态由 metrics server 的 /leaderz 单独暴露
+	err = mgr.AddHealthzCheck("ping", healthz.Ping)
 	if err != nil {
-		klog.Error(err, "unable add schema")
+		klog.Error(err, "unable to set up health check")
+		os.Exit(1)
+	}
+	apiServerCheck, err := health.APIServerReachable(restConfig)
+	if err != nil {
+		klog.Error(err, "unable to set up ready check")
+		os.Exit(1)
+	}
+	readyChecks := map[string]healthz.Checker{
+		"cache-sync": health.CacheSynced(mgr.GetCache()),
+		"apiserver":  apiServerCheck,
+	}
+	for name, check := range readyChecks {
+		err = mgr.AddReadyzCheck(name, check)
+		if err != nil {
+			klog.Error(err, "unable to set up ready check")
+			os.Exit(1)
+		}
+	}
+	// 当前副本为 leader 时返回 200，否则返回 500，不影响 /readyz
+	err = mgr.AddMetricsExtraHandler("/leaderz", healthz.CheckHandler{Checker: health.LeaderElected(mgr.Elected())})
+	if err != nil {
+		klog.Error(err, "unable to set up leader check")
 		os.Exit(1)
 	}
 
 	// 3. 控制器相关
-	clusterConfigCtl := controller.NewClusterConfigController(mgr.GetClient(), mgr.GetLogger(), mgr.GetScheme(), mgr.GetEventRecorderFor("cluster-config-recorder"))
+	clusterConfigCtl := controller.NewClusterConfigController(mgr.GetClient(), mgr.GetAPIReader(), mgr.GetLogger(), mgr.GetScheme(), mgr.GetEventRecorderFor("cluster-config-recorder"))
+	clusterConfigCtl.NamespaceWorkers = opts.NamespaceWorkers
+	clusterConfigCtl.PolicyDefaults = opts.Defaults
+	clusterConfigCtl.DrainTimeout = opts.DrainTimeout()
 
-	err = builder.ControllerManagedBy(mgr).For(&clusterconfigv1alpha1.ClusterConfig{}).
+	// status 更新不会改变 generation，不再触发调协；ConfigMap Secret 只处理带管理 label 的资源对象
+	err = builder.ControllerManagedBy(mgr).
+		For(&clusterconfigv1alpha1.ClusterConfig{}, builder.WithPredicates(controller.ClusterConfigPredicate())).
+		WithOptions(ctrlcontroller.Options{MaxConcurrentReconciles: opts.MaxConcurrentReconciles}).
 		Watches(&source.Kind{Type: &v1.ConfigMap{}},
 			handler.Funcs{
 				UpdateFunc: clusterConfigCtl.OnUpdateConfigHandlerByClusterConfig,
 				DeleteFunc: clusterConfigCtl.OnDeleteConfigHandlerByClusterConfig,
-			}).
+			}, builder.WithPredicates(controller.ManagedCopyPredicate())).
 		Watches(&source.Kind{Type: &v1.Secret{}},
 			handler.Funcs{
 				UpdateFunc: clusterConfigCtl.OnUpdateConfigHandlerByClusterConfig,
 				DeleteFunc: clusterConfigCtl.OnDeleteConfigHandlerByClusterConfig,
+			}, builder.WithPredicates(controller.ManagedCopyPredicate())).
+		Watches(&source.Kind{Type: &v1.Namespace{}},
+			handler.Funcs{
+				CreateFunc: clusterConfigCtl.OnCreateNamespaceHandlerByClusterConfig,
+				UpdateFunc: clusterConfigCtl.OnUpdateNamespaceHandlerByClusterConfig,
+				DeleteFunc: clusterConfigCtl.OnDeleteNamespaceHandlerByClusterConfig,
 			}).
 		Complete(clusterConfigCtl)
+	if err != nil {
+		klog.Error(err, "unable to set up controller")
+		os.Exit(1)
+	}
 
-	errC := make(chan error)
-
+	// 收到 SIGTERM 后停止接收新的调协，等待正在进行的调协结束后释放 Lease 并退出
 	if err = mgr.Start(signals.SetupSignalHandler()); err != nil {
-		errC <- err
+		klog.Error(err, "problem running manager")
+		os.Exit(1)
 	}
+	klog.Info("manager stopped")
 }
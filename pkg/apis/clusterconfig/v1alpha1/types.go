@@ -19,21 +19,152 @@ type ClusterConfig struct {
 }
 
 type ClusterConfigSpec struct {
-	// NamespaceList namespace 列表
+	// NamespaceList namespace 列表，逗号分隔，支持 all、glob（team-*）与正则（/^team-.*$/）
 	NamespaceList string `json:"namespaceList,omitempty"`
+	// NamespaceSelector 按 label 选择目标 namespace，与 NamespaceList 取并集
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+	// ExcludeNamespaces 排除的 namespace，写法同 NamespaceList，优先级高于 NamespaceList 与 NamespaceSelector
+	ExcludeNamespaces []string `json:"excludeNamespaces,omitempty"`
 	// ConfigType 配置文件类型：支持 configmaps secrets
 	ConfigType string `json:"configType,omitempty"`
 	// Data 用于存储配置
 	Data map[string]string `json:"data,omitempty"`
-	Type v1.SecretType     `json:"type,omitempty"`
+	// BinaryData 二进制配置，yaml 中以 base64 填写：
+	// configmaps 写入 ConfigMap.BinaryData，secrets 原样写入 Secret.Data
+	BinaryData map[string][]byte `json:"binaryData,omitempty"`
+	// StringData 仅 secrets 使用，与原生 Secret.StringData 一致，同名 key 覆盖 Data 与 BinaryData
+	StringData map[string]string `json:"stringData,omitempty"`
+	Type       v1.SecretType     `json:"type,omitempty"`
+	// TargetName 下发资源对象的名称，默认与 ClusterConfig 同名
+	// 支持 ${name} ${namespace} 占位符，分别替换为 ClusterConfig 的名称与所在 namespace
+	TargetName string `json:"targetName,omitempty"`
+	// AdoptionPolicy 目标 namespace 中已存在同名且非本 ClusterConfig 管理的资源对象时的处理方式，默认 Never
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+	// DeletionPolicy 删除 ClusterConfig 或 namespace 不再匹配时，对已下发资源对象的处理方式，默认 Delete
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+	// DriftPolicy 已下发的资源对象被手动修改或删除时的处理方式，默认 Enforce
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+	// Template 下发的 ConfigMap Secret 模版
+	Template *ClusterConfigTemplate `json:"template,omitempty"`
+}
+
+// AdoptionPolicy 接管已存在资源对象的策略
+type AdoptionPolicy string
+
+const (
+	// AdoptionPolicyNever 不接管，记录 Conflict
+	AdoptionPolicyNever AdoptionPolicy = "Never"
+	// AdoptionPolicyIfMatching 内容与期望一致时接管，否则记录 Conflict
+	AdoptionPolicyIfMatching AdoptionPolicy = "IfMatching"
+	// AdoptionPolicyAlways 总是接管并覆盖
+	AdoptionPolicyAlways AdoptionPolicy = "Always"
+)
+
+// DeletionPolicy 已下发资源对象的删除策略
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete 删除资源对象
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyOrphan 移除管理 label 后保留资源对象
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+	// DeletionPolicyRetain 原样保留资源对象，不再更新
+	DeletionPolicyRetain DeletionPolicy = "Retain"
+)
+
+// DriftPolicy 已下发资源对象被手动修改时的处理策略
+type DriftPolicy string
+
+const (
+	// DriftPolicyEnforce 恢复为期望内容
+	DriftPolicyEnforce DriftPolicy = "Enforce"
+	// DriftPolicyWarn 保留修改，发出 Event 并记录 Drifted condition
+	DriftPolicyWarn DriftPolicy = "Warn"
+	// DriftPolicyIgnore 保留修改，不做处理
+	DriftPolicyIgnore DriftPolicy = "Ignore"
+)
+
+// ClusterConfigFinalizer 删除 ClusterConfig 前清理所有下发资源对象
+const ClusterConfigFinalizer = "api.practice.com/clusterconfig-cleanup"
+
+// 下发资源对象上标记所属 ClusterConfig 的 label
+const (
+	LabelClusterConfigName      = "api.practice.com/clusterconfig-name"
+	LabelClusterConfigNamespace = "api.practice.com/clusterconfig-namespace"
+	LabelClusterConfigUID       = "api.practice.com/clusterconfig-uid"
+	LabelManagedBy              = "app.kubernetes.io/managed-by"
+	ManagedByClusterConfig      = "clusterconfig-operator"
+)
+
+// AnnotationContentHash 下发资源对象上记录期望内容（data labels annotations type）的 hash
+const AnnotationContentHash = "api.practice.com/clusterconfig-hash"
+
+// ClusterConfigTemplate 下发资源对象的模版
+type ClusterConfigTemplate struct {
+	// Metadata 合并到每个下发资源对象上的 labels annotations
+	Metadata TemplateMetadata `json:"metadata,omitempty"`
+}
+
+// TemplateMetadata 模版 metadata
+type TemplateMetadata struct {
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 // ClusterConfigStatus status 状态
 type ClusterConfigStatus struct {
 	// ProcessedNamespace 记录已经执行完的 namespace
 	ProcessedNamespace []string `json:"processedNamespace"`
+	// ObservedGeneration 最近一次调协时的 metadata.generation
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastSyncTime 最近一次成功同步的时间
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
+	// Conditions 调协状态：Ready Progressing Degraded Conflict Drifted
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Namespaces 每个目标 namespace 的同步状态
+	Namespaces []NamespaceStatus `json:"namespaces,omitempty"`
+}
+
+// NamespaceStatus 单个 namespace 的同步状态
+type NamespaceStatus struct {
+	// Namespace 目标 namespace
+	Namespace string `json:"namespace"`
+	// Phase 同步阶段：Synced Failed Pending Conflict
+	Phase NamespacePhase `json:"phase"`
+	// LastError 最近一次同步失败的错误信息
+	LastError string `json:"lastError,omitempty"`
+	// ContentHash 最近一次同步成功的内容 hash
+	ContentHash string `json:"contentHash,omitempty"`
+	// ResourceVersion 最近一次同步成功后资源对象的 resourceVersion，未变化时跳过同步
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// LastSyncTime 最近一次同步成功的时间
+	LastSyncTime *metav1.Time `json:"lastSyncTime,omitempty"`
 }
 
+type NamespacePhase string
+
+const (
+	NamespacePhaseSynced   NamespacePhase = "Synced"
+	NamespacePhaseFailed   NamespacePhase = "Failed"
+	NamespacePhasePending  NamespacePhase = "Pending"
+	NamespacePhaseConflict NamespacePhase = "Conflict"
+	NamespacePhaseDrifted  NamespacePhase = "Drifted"
+)
+
+// Condition 类型
+const (
+	// ConditionReady 所有目标 namespace 已同步完成
+	ConditionReady = "Ready"
+	// ConditionProgressing 仍有未同步完成的变更，等待重试
+	ConditionProgressing = "Progressing"
+	// ConditionDegraded 最近一次调协失败
+	ConditionDegraded = "Degraded"
+	// ConditionConflict 部分 namespace 中的资源对象被其他 ClusterConfig 或用户占用，已跳过
+	ConditionConflict = "Conflict"
+	// ConditionDrifted 部分 namespace 中的资源对象被手动修改，driftPolicy 为 Warn 时保留了修改
+	ConditionDrifted = "Drifted"
+)
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // ClusterConfigList
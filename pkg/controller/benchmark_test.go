@@ -0,0 +1,127 @@
+package controller
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"testing"
+	"time"
+)
+
+// BenchmarkHandleConfigmaps 首次下发到不同数量 namespace 的耗时，
+// fake client 没有网络延迟且内部串行，这里主要体现随 namespace 数量的增长
+// go test ./pkg/controller -run '^$' -bench HandleConfigmaps -benchmem
+func BenchmarkHandleConfigmaps(b *testing.B) {
+	discardKlog()
+
+	for _, namespaces := range []int{10, 100, 1000, 3000} {
+		for _, workers := range []int{1, DefaultNamespaceWorkers} {
+			b.Run(fmt.Sprintf("namespaces=%d/workers=%d", namespaces, workers), func(b *testing.B) {
+				benchmarkHandleConfigmaps(b, namespaces, workers, 0)
+			})
+		}
+	}
+}
+
+// BenchmarkHandleConfigmapsWithLatency 每个请求增加 1ms 延迟模拟 apiserver 往返，体现并发 worker 的收益
+func BenchmarkHandleConfigmapsWithLatency(b *testing.B) {
+	discardKlog()
+	for _, namespaces := range []int{100, 1000} {
+		for _, workers := range []int{1, DefaultNamespaceWorkers, 50} {
+			b.Run(fmt.Sprintf("namespaces=%d/workers=%d", namespaces, workers), func(b *testing.B) {
+				benchmarkHandleConfigmaps(b, namespaces, workers, time.Millisecond)
+			})
+		}
+	}
+}
+
+// BenchmarkHandleConfigmapsUpToDate 资源对象都已同步时再次调协的耗时，每个请求增加 1ms 延迟，
+// 只需要一次 list，不会逐个获取资源对象（fake client 会忽略 UnsafeDisableDeepCopy）
+func BenchmarkHandleConfigmapsUpToDate(b *testing.B) {
+	discardKlog()
+	for _, namespaces := range []int{100, 1000} {
+		b.Run(fmt.Sprintf("namespaces=%d", namespaces), func(b *testing.B) {
+			namespaceList := make([]string, 0, namespaces)
+			for i := 0; i < namespaces; i++ {
+				namespaceList = append(namespaceList, fmt.Sprintf("ns-%d", i))
+			}
+			cc := newTestClusterConfig()
+			r, _ := newTestController(b, cc)
+			resetNamespaceStatus(cc, namespaceList)
+			if err := r.handleConfigmaps(context.Background(), cc, namespaceList); err != nil {
+				b.Fatal(err)
+			}
+			r.client = &latencyClient{Client: r.client, latency: time.Millisecond}
+			r.apiReader = r.client
+			b.ResetTimer()
+
+			for i := 0; i < b.N; i++ {
+				if err := r.handleConfigmaps(context.Background(), cc, namespaceList); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func benchmarkHandleConfigmaps(b *testing.B, namespaces, workers int, latency time.Duration) {
+	namespaceList := make([]string, 0, namespaces)
+	for i := 0; i < namespaces; i++ {
+		namespaceList = append(namespaceList, fmt.Sprintf("ns-%d", i))
+	}
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		cc := newTestClusterConfig()
+		objs := []client.Object{cc}
+		for _, namespace := range namespaceList {
+			objs = append(objs, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: namespace}})
+		}
+		r, c := newTestController(b, objs...)
+		if latency > 0 {
+			r.client = &latencyClient{Client: c, latency: latency}
+			r.apiReader = r.client
+		}
+		r.NamespaceWorkers = workers
+		resetNamespaceStatus(cc, namespaceList)
+		b.StartTimer()
+
+		if err := r.handleConfigmaps(context.Background(), cc, namespaceList); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// discardKlog 每个 namespace 都会打印日志，避免影响结果
+func discardKlog() {
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	_ = fs.Set("logtostderr", "false")
+	_ = fs.Set("stderrthreshold", "FATAL")
+	klog.SetOutput(io.Discard)
+}
+
+// latencyClient 读写请求前等待 latency
+type latencyClient struct {
+	client.Client
+	latency time.Duration
+}
+
+func (c *latencyClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	time.Sleep(c.latency)
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *latencyClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	time.Sleep(c.latency)
+	return c.Client.List(ctx, list, opts...)
+}
+
+func (c *latencyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	time.Sleep(c.latency)
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
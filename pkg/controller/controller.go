@@ -6,7 +6,7 @@ import (
 	"github.com/go-logr/logr"
 	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
 	"github.com/myoperator/clusterconfigoperator/pkg/common"
-	v1 "k8s.io/api/core/v1"
+	"github.com/myoperator/clusterconfigoperator/pkg/metrics"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/tools/record"
@@ -19,17 +19,66 @@ import (
 	"time"
 )
 
+// DefaultNamespaceWorkers 未设置 NamespaceWorkers 时并发同步 namespace 的 worker 数量
+const DefaultNamespaceWorkers = 10
+
 type ClusterConfigController struct {
 	// 增加事件通知器
-	client        client.Client
+	client client.Client
+	// apiReader 直接读取 apiserver，cache 中只有带管理 label 的 ConfigMap Secret
+	apiReader     client.Reader
 	Scheme        *runtime.Scheme
 	log           logr.Logger
 	EventRecorder record.EventRecorder
+	// NamespaceWorkers 单个 ClusterConfig 并发同步 namespace 的 worker 数量
+	NamespaceWorkers int
+	// PolicyDefaults ClusterConfig 未设置策略时使用的默认值
+	PolicyDefaults PolicyDefaults
+	// DrainTimeout 收到 SIGTERM 后正在进行的调协继续执行的时间，超时后取消调协并记录已完成的 namespace，
+	// 需要小于 manager 的 GracefulShutdownTimeout，为 0 时随 SIGTERM 立即取消
+	DrainTimeout time.Duration
+}
+
+// PolicyDefaults 各策略的默认值，为空时 adoptionPolicy 为 Never，deletionPolicy 为 Delete，driftPolicy 为 Enforce
+type PolicyDefaults struct {
+	AdoptionPolicy clusterconfigv1alpha1.AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+	DeletionPolicy clusterconfigv1alpha1.DeletionPolicy `json:"deletionPolicy,omitempty"`
+	DriftPolicy    clusterconfigv1alpha1.DriftPolicy    `json:"driftPolicy,omitempty"`
+}
+
+// Validate 校验策略的取值，空值代表使用默认值
+func (p PolicyDefaults) Validate() error {
+	switch p.AdoptionPolicy {
+	case "", clusterconfigv1alpha1.AdoptionPolicyNever, clusterconfigv1alpha1.AdoptionPolicyIfMatching, clusterconfigv1alpha1.AdoptionPolicyAlways:
+	default:
+		return fmt.Errorf("unknown adoptionPolicy %q, must be one of Never, IfMatching, Always", p.AdoptionPolicy)
+	}
+	switch p.DeletionPolicy {
+	case "", clusterconfigv1alpha1.DeletionPolicyDelete, clusterconfigv1alpha1.DeletionPolicyOrphan, clusterconfigv1alpha1.DeletionPolicyRetain:
+	default:
+		return fmt.Errorf("unknown deletionPolicy %q, must be one of Delete, Orphan, Retain", p.DeletionPolicy)
+	}
+	switch p.DriftPolicy {
+	case "", clusterconfigv1alpha1.DriftPolicyEnforce, clusterconfigv1alpha1.DriftPolicyWarn, clusterconfigv1alpha1.DriftPolicyIgnore:
+	default:
+		return fmt.Errorf("unknown driftPolicy %q, must be one of Enforce, Warn, Ignore", p.DriftPolicy)
+	}
+	return nil
+}
+
+// validatePolicies 校验 ClusterConfig 中设置的策略，与启动参数中的默认值使用相同的校验
+func validatePolicies(clusterConfig *clusterconfigv1alpha1.ClusterConfig) error {
+	return PolicyDefaults{
+		AdoptionPolicy: clusterConfig.Spec.AdoptionPolicy,
+		DeletionPolicy: clusterConfig.Spec.DeletionPolicy,
+		DriftPolicy:    clusterConfig.Spec.DriftPolicy,
+	}.Validate()
 }
 
-func NewClusterConfigController(client client.Client, log logr.Logger, scheme *runtime.Scheme, eventRecorder record.EventRecorder) *ClusterConfigController {
+func NewClusterConfigController(client client.Client, apiReader client.Reader, log logr.Logger, scheme *runtime.Scheme, eventRecorder record.EventRecorder) *ClusterConfigController {
 	return &ClusterConfigController{
 		client:        client,
+		apiReader:     apiReader,
 		log:           log,
 		Scheme:        scheme,
 		EventRecorder: eventRecorder,
@@ -38,6 +87,10 @@ func NewClusterConfigController(client client.Client, log logr.Logger, scheme *r
 
 // Reconcile 调协 loop
 func (r *ClusterConfigController) Reconcile(ctx context.Context, req reconcile.Request) (reconcile.Result, error) {
+	// 收到 SIGTERM 后 manager 取消 ctx，并在 GracefulShutdownTimeout 内等待正在进行的调协结束；
+	// 调协在 DrainTimeout 后才取消，尽量同步完所有 namespace，剩余时间用于记录 status
+	ctx, cancel := r.drainContext(ctx)
+	defer cancel()
 
 	// 调协时先获取该资源对象
 	clusterconfig := &clusterconfigv1alpha1.ClusterConfig{}
@@ -48,6 +101,7 @@ func (r *ClusterConfigController) Reconcile(ctx context.Context, req reconcile.R
 			return reconcile.Result{Requeue: true, RequeueAfter: time.Second * 60}, err
 		}
 		// 如果未找到的错误，不再进入调协
+		metrics.DeleteClusterConfig(req.Namespace, req.Name)
 		return reconcile.Result{}, nil
 	}
 
@@ -55,92 +109,170 @@ func (r *ClusterConfigController) Reconcile(ctx context.Context, req reconcile.R
 		clusterconfig.Status.ProcessedNamespace = make([]string, 0)
 	}
 
+	// 已删除的 namespace 不再处理，先从 status 中清理掉
+	err = r.pruneDeletedNamespaces(ctx, clusterconfig)
+	if err != nil {
+		klog.Error("prune deleted namespace err: ", err)
+		return r.requeueWithError(ctx, clusterconfig, ReasonPruneNamespaceFailed, err)
+	}
+
+	// 策略的取值不合法时不做任何处理，避免按默认的 Delete 误删资源对象
+	err = validatePolicies(clusterconfig)
+	if err != nil {
+		klog.Error("validate policy err: ", err)
+		return r.requeueWithError(ctx, clusterconfig, ReasonInvalidPolicy, err)
+	}
+
 	// 处理删除状态，会等到 Finalizer 字段清空后才会真正删除
-	// 1、删除所有 ns 下资源
+	// 1、按 label 删除所有 ns 下资源
 	// 2、清空 Finalizer，更新状态
 	if !clusterconfig.DeletionTimestamp.IsZero() {
+		// Finalizer 已清理，对象即将被删除
+		if !controllerutil.ContainsFinalizer(clusterconfig, clusterconfigv1alpha1.ClusterConfigFinalizer) && len(legacyFinalizers(clusterconfig)) == 0 {
+			metrics.DeleteClusterConfig(clusterconfig.Namespace, clusterconfig.Name)
+			return reconcile.Result{}, nil
+		}
 		err = r.deleteResource(ctx, clusterconfig)
 		if err != nil {
 			klog.Error(err, "delete resource: ", clusterconfig.GetName()+"/"+clusterconfig.GetNamespace(), " failed")
-			//mc.EventRecorder.Event(rr, corev1.EventTypeWarning, "Delete", fmt.Sprintf("delete %s fail", rr.Name))
-			return reconcile.Result{Requeue: true, RequeueAfter: time.Second * 60}, err
+			return r.requeueWithError(ctx, clusterconfig, ReasonDeleteFailed, err)
 		}
 		klog.Info("successful delete clusterconfig")
+		metrics.DeleteClusterConfig(clusterconfig.Namespace, clusterconfig.Name)
 		return reconcile.Result{}, nil
 	}
 
-	// 1. 先分割出目标 namespace
-	namespaceList := splitString(clusterconfig.Spec.NamespaceList, ",")
+	// 校验 ClusterConfig 的名称能否写入 label
+	err = validateOwnerLabels(clusterconfig)
+	if err != nil {
+		klog.Error("validate clusterconfig name err: ", err)
+		return r.requeueWithError(ctx, clusterconfig, ReasonInvalidName, err)
+	}
+
+	// 校验下发资源对象的名称
+	err = validateTargetName(clusterconfig)
+	if err != nil {
+		klog.Error("validate target name err: ", err)
+		return r.requeueWithError(ctx, clusterconfig, ReasonInvalidTargetName, err)
+	}
 
-	/* FIXME: 如果要实现类似管理特定 namespace 功能，可能需要一个 status 记录 已经创建完成的 namespaceList
-	1. 进入调协时，先比对 namespaceList 与 status namespaceList 的区别，如果
-	status namespaceList 中有 namespaceList 没有的部分，直接删除
-	2. 当创建时，就加入 status namespaceList list 中
-	*/
+	// 1. 先计算出目标 namespace：NamespaceList 与 NamespaceSelector 的并集
+	namespaceList, err := r.targetNamespaces(ctx, clusterconfig)
+	if err != nil {
+		klog.Error("calculate target namespace err: ", err)
+		return r.requeueWithError(ctx, clusterconfig, ReasonTargetNamespaceFailed, err)
+	}
 
-	// 如果 cr 的 status ProcessedNamespace 字段长度不为 0，代表已经是处理后的资源对象，需要进入
-	if len(clusterconfig.Status.ProcessedNamespace) != 0 {
-		resList := calculateNeedToDeleteNamespace(namespaceList, clusterconfig.Status.ProcessedNamespace)
-		// 遍历删除此namespace下的资源对象
-		err := r.deleteResourceByNamespace(ctx, clusterconfig, resList)
-		if err != nil {
-			klog.Error(err, "delete resource: ", clusterconfig.GetName()+"/"+clusterconfig.GetNamespace(), " failed")
-			r.EventRecorder.Eventf(clusterconfig, v1.EventTypeWarning, "Delete", fmt.Sprintf("delete %s clusterConfig error: %s", clusterconfig.Name, err.Error()))
-			return reconcile.Result{Requeue: true, RequeueAfter: time.Second * 60}, err
-		}
+	// 与其他 ClusterConfig 写入同一资源对象的 namespace，交由先创建的 ClusterConfig 处理
+	conflicts, err := r.targetConflicts(ctx, clusterconfig, namespaceList)
+	if err != nil {
+		klog.Error("calculate target conflict err: ", err)
+		return r.requeueWithError(ctx, clusterconfig, ReasonTargetNamespaceFailed, err)
+	}
+	conflictNamespaces := make([]string, 0, len(conflicts))
+	for namespace := range conflicts {
+		conflictNamespaces = append(conflictNamespaces, namespace)
+	}
+	namespaceList = subtractStrings(namespaceList, conflictNamespaces)
+	r.recordNamespaceEvents(clusterconfig, targetKind(clusterconfig), targetName(clusterconfig), namespaceEvents{EventReasonConflictSkipped: conflictNamespaces})
+
+	// 删除不再需要的资源对象：按 label 列出所有 namespace 下的资源对象，
+	// 所在 namespace 已不是目标，或者 targetName configType 已变更的都会被删除
+	cleaned, err := r.cleanupStaleCopies(ctx, clusterconfig, namespaceList)
+	if err != nil {
+		klog.Error(err, "delete resource: ", clusterconfig.GetName()+"/"+clusterconfig.GetNamespace(), " failed")
+		// 已清理完成的 namespace 先从 status 中移除，失败的 namespace 下次调协重试
+		clusterconfig.Status.ProcessedNamespace = subtractStrings(clusterconfig.Status.ProcessedNamespace, cleaned)
+		return r.requeueWithError(ctx, clusterconfig, ReasonDeleteFailed, err)
+	}
+	if len(cleaned) != 0 {
+		klog.Infof("cleaned stale resources in namespace: %v", cleaned)
+	}
+	// 旧版本的 all 在同步完成后才从 status 中移除，接管旧版本资源对象时需要
+	removed := subtractStrings(clusterconfig.Status.ProcessedNamespace, append([]string{allNamespace}, namespaceList...))
+	r.recordNamespaceEvents(clusterconfig, targetKind(clusterconfig), targetName(clusterconfig), namespaceEvents{EventReasonNamespaceRemoved: removed})
+	if len(removed) != 0 {
 		// 更新 status 字段
-		clusterconfig.Status.ProcessedNamespace = namespaceList
+		clusterconfig.Status.ProcessedNamespace = subtractStrings(clusterconfig.Status.ProcessedNamespace, removed)
 		err = r.client.Status().Update(ctx, clusterconfig)
 		if err != nil {
-			r.EventRecorder.Eventf(clusterconfig, v1.EventTypeWarning, "UpdateStatus", fmt.Sprintf("update %s clusterConfig status error: %s", clusterconfig.Name, err.Error()))
 			klog.Error("update clusterconfig status err: ", err)
-			return reconcile.Result{Requeue: true, RequeueAfter: time.Second * 60}, err
+			return r.requeueWithError(ctx, clusterconfig, ReasonStatusUpdateFailed, err)
 		}
 	}
 
 	// 设置 crd 对象的 Finalizer 字段，并判断是否改变
-	// 3. 检查是否已添加 Finalizer
-	needToAdd := containsFinalizer(clusterconfig, namespaceList)
-	if len(needToAdd) != 0 {
-		// 添加 Finalizer
-		for _, v := range needToAdd {
-			controllerutil.AddFinalizer(clusterconfig, v)
-			err = r.client.Update(ctx, clusterconfig)
-			if err != nil {
-				klog.Error("update clusterconfig finalizer err: ", err)
-				r.EventRecorder.Eventf(clusterconfig, v1.EventTypeWarning, "UpdateStatus", fmt.Sprintf("update %s clusterConfig finalizer error: %s", clusterconfig.Name, err.Error()))
-				return reconcile.Result{Requeue: true, RequeueAfter: time.Second * 60}, err
-			}
+	// 3. 检查是否已添加 Finalizer，旧版本以 namespace 命名的 Finalizer 在对应资源对象接管或清理后移除
+	pending, err := r.legacyCopyNamespaces(ctx, clusterconfig)
+	if err != nil {
+		klog.Error("list legacy resources err: ", err)
+		return r.requeueWithError(ctx, clusterconfig, ReasonFinalizerUpdateFailed, err)
+	}
+	if ensureFinalizer(clusterconfig, pending) {
+		err = r.client.Update(ctx, clusterconfig)
+		if err != nil {
+			klog.Error("update clusterconfig finalizer err: ", err)
+			return r.requeueWithError(ctx, clusterconfig, ReasonFinalizerUpdateFailed, err)
 		}
-		// 需要注意
 	}
 
+	// 新增的 namespace 先记为 Pending，再逐个同步
+	added := make([]string, 0)
+	for _, namespace := range namespaceList {
+		if findNamespaceStatus(clusterconfig, namespace) == nil {
+			added = append(added, namespace)
+		}
+	}
+	resetNamespaceStatus(clusterconfig, namespaceList)
+	r.recordNamespaceEvents(clusterconfig, targetKind(clusterconfig), targetName(clusterconfig), namespaceEvents{EventReasonNamespaceAdded: added})
+
 	// 区分 configmaps or secrets
 	switch clusterconfig.Spec.ConfigType {
 	case common.ConfigMaps:
 		// 处理 secrets 类型
-		err = r.handleConfigmaps(ctx, clusterconfig)
+		err = r.handleConfigmaps(ctx, clusterconfig, namespaceList)
 		if err != nil {
-			r.EventRecorder.Eventf(clusterconfig, v1.EventTypeWarning, "Handle", fmt.Sprintf("handle %s clusterConfig configmap error: %s", clusterconfig.Name, err.Error()))
-			return reconcile.Result{Requeue: true, RequeueAfter: time.Second * 60}, err
+			return r.requeueWithError(ctx, clusterconfig, ReasonSyncFailed, err)
 		}
 		// 处理 configmaps 类型
 	case common.Secrets:
 		// 处理 secrets 类型
-		err = r.handleSecrets(ctx, clusterconfig)
+		err = r.handleSecrets(ctx, clusterconfig, namespaceList)
 		if err != nil {
-			r.EventRecorder.Eventf(clusterconfig, v1.EventTypeWarning, "Handle", fmt.Sprintf("handle %s clusterConfig secrets error: %s", clusterconfig.Name, err.Error()))
-			return reconcile.Result{Requeue: true, RequeueAfter: time.Second * 60}, err
+			return r.requeueWithError(ctx, clusterconfig, ReasonSyncFailed, err)
 		}
 	}
 
-	// 更新 status 字段
-	clusterconfig.Status.ProcessedNamespace = namespaceList
-	err = r.client.Status().Update(ctx, clusterconfig)
+	// 更新 status 字段，被占用而跳过的 namespace 不记录
+	clusterconfig.Status.ProcessedNamespace = subtractStrings(namespaceList, conflictedNamespaces(clusterconfig))
+	if len(conflicts) != 0 {
+		err = targetConflictError(clusterconfig, conflicts)
+		return r.requeueWithError(ctx, clusterconfig, ReasonTargetNameConflict, err)
+	}
+	setSyncedStatus(clusterconfig)
+	metrics.RecordStatus(clusterconfig)
+	statusCtx, cancelStatus := statusContext(ctx)
+	defer cancelStatus()
+	err = r.client.Status().Update(statusCtx, clusterconfig)
 	if err != nil {
-		r.EventRecorder.Eventf(clusterconfig, v1.EventTypeWarning, "UpdateStatus", fmt.Sprintf("update %s clusterConfig status error: %s", clusterconfig.Name, err.Error()))
 		klog.Error("update clusterconfig status err: ", err)
-		return reconcile.Result{Requeue: true, RequeueAfter: time.Second * 60}, err
+		return r.requeueWithError(ctx, clusterconfig, ReasonStatusUpdateFailed, err)
+	}
+
+	// 本次调协接管的旧版本资源对象已带有 label，移除对应 namespace 的 Finalizer
+	if len(legacyFinalizers(clusterconfig)) != 0 {
+		pending, err = r.legacyCopyNamespaces(ctx, clusterconfig)
+		if err != nil {
+			klog.Error("list legacy resources err: ", err)
+			return r.requeueWithError(ctx, clusterconfig, ReasonFinalizerUpdateFailed, err)
+		}
+		if removeLegacyFinalizers(clusterconfig, pending) {
+			err = r.client.Update(ctx, clusterconfig)
+			if err != nil {
+				klog.Error("update clusterconfig finalizer err: ", err)
+				return r.requeueWithError(ctx, clusterconfig, ReasonFinalizerUpdateFailed, err)
+			}
+		}
 	}
 
 	klog.Info("successful reconcile")
@@ -148,27 +280,79 @@ func (r *ClusterConfigController) Reconcile(ctx context.Context, req reconcile.R
 	return reconcile.Result{}, nil
 }
 
+// OnUpdateConfigHandlerByClusterConfig 下发的资源对象没有 owner references，按 label 找到所属 ClusterConfig 重新入列，
+// 修改前后任意一方带有 label 即入列，以便处理手动修改与移除 label 的情况
 func (r *ClusterConfigController) OnUpdateConfigHandlerByClusterConfig(event event.UpdateEvent, limitingInterface workqueue.RateLimitingInterface) {
-	for _, ref := range event.ObjectNew.GetOwnerReferences() {
-		if ref.Kind == clusterconfigv1alpha1.ClusterConfigKind && ref.APIVersion == clusterconfigv1alpha1.ClusterConfigApiVersion {
-			// 重新放入 Reconcile 调协方法
-			limitingInterface.Add(reconcile.Request{
-				NamespacedName: types.NamespacedName{
-					Name: ref.Name, Namespace: event.ObjectNew.GetNamespace(),
-				},
-			})
-		}
+	// resync 或只有 status 等字段变更时 resourceVersion 不变
+	if event.ObjectOld.GetResourceVersion() == event.ObjectNew.GetResourceVersion() {
+		return
 	}
+	enqueueClusterConfigByLabels(limitingInterface, event.ObjectOld)
+	enqueueClusterConfigByLabels(limitingInterface, event.ObjectNew)
 }
 
 func (r *ClusterConfigController) OnDeleteConfigHandlerByClusterConfig(event event.DeleteEvent, limitingInterface workqueue.RateLimitingInterface) {
-	for _, ref := range event.Object.GetOwnerReferences() {
-		if ref.Kind == clusterconfigv1alpha1.ClusterConfigKind && ref.APIVersion == clusterconfigv1alpha1.ClusterConfigApiVersion {
-			// 重新入列
-			klog.Info("delete pod: ", event.Object.GetName(), event.Object.GetObjectKind())
-			limitingInterface.Add(reconcile.Request{
-				NamespacedName: types.NamespacedName{Name: ref.Name,
-					Namespace: event.Object.GetNamespace()}})
+	klog.Info("delete object: ", event.Object.GetNamespace(), "/", event.Object.GetName())
+	enqueueClusterConfigByLabels(limitingInterface, event.Object)
+}
+
+// enqueueClusterConfigByLabels 按资源对象上的 label 找到所属 ClusterConfig 并入列
+func enqueueClusterConfigByLabels(limitingInterface workqueue.RateLimitingInterface, obj client.Object) {
+	labels := obj.GetLabels()
+	name, namespace := labels[clusterconfigv1alpha1.LabelClusterConfigName], labels[clusterconfigv1alpha1.LabelClusterConfigNamespace]
+	if name == "" || namespace == "" {
+		return
+	}
+	// 重新放入 Reconcile 调协方法
+	limitingInterface.Add(reconcile.Request{
+		NamespacedName: types.NamespacedName{Name: name, Namespace: namespace},
+	})
+}
+
+// drainContext parent 取消后再等待 DrainTimeout 才取消，保留 parent 中的值（日志、reconcileID）
+func (r *ClusterConfigController) drainContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if r.DrainTimeout <= 0 {
+		return context.WithCancel(parent)
+	}
+	ctx, cancel := context.WithCancel(detachedContext{parent: parent})
+	go func() {
+		select {
+		case <-parent.Done():
+		case <-ctx.Done():
+			return
+		}
+		timer := time.NewTimer(r.DrainTimeout)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			klog.Info("drain timeout exceeded, cancel reconcile")
+			cancel()
+		case <-ctx.Done():
 		}
+	}()
+	return ctx, cancel
+}
+
+// statusUpdateTimeout 调协已取消时写入 status 的超时时间
+const statusUpdateTimeout = 5 * time.Second
+
+// statusContext 调协已取消时使用单独的 ctx 写入 status，记录已完成与未完成的 namespace
+func statusContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if ctx.Err() == nil {
+		return ctx, func() {}
 	}
+	return context.WithTimeout(detachedContext{parent: ctx}, statusUpdateTimeout)
+}
+
+// detachedContext 保留 parent 中的值，但不随 parent 取消
+type detachedContext struct {
+	parent context.Context
 }
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (detachedContext) Done() <-chan struct{} { return nil }
+
+func (detachedContext) Err() error { return nil }
+
+func (c detachedContext) Value(key interface{}) interface{} { return c.parent.Value(key) }
@@ -0,0 +1,309 @@
+package controller
+
+import (
+	"context"
+	stderrors "errors"
+	"fmt"
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 关闭过程中 ctx 已取消，DrainTimeout 内正在进行的调协仍然同步完所有 namespace
+func TestReconcileDrainsAfterShutdown(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	cc.Spec.NamespaceList = "ns1,ns2"
+	r, c := newTestController(t, cc,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}},
+	)
+	r.NamespaceWorkers = 1
+	r.DrainTimeout = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(cc)}); err != nil {
+		t.Fatal(err)
+	}
+	for _, namespace := range []string{"ns1", "ns2"} {
+		if err := c.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: "test"}, &v1.ConfigMap{}); err != nil {
+			t.Errorf("expected copy in %s: %v", namespace, err)
+		}
+	}
+}
+
+// 超过 DrainTimeout 后调协取消，未同步的 namespace 保持原状态，失败原因写入 status，下次调协重试
+func TestReconcileCancelledAfterDrainTimeout(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	cc.Spec.NamespaceList = "ns1,ns2"
+	r, c := newTestController(t, cc,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}},
+	)
+	r.NamespaceWorkers = 1
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := r.Reconcile(ctx, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(cc)})
+	if !stderrors.Is(err, context.Canceled) {
+		t.Fatalf("expected context canceled, got %v", err)
+	}
+	stored := &clusterconfigv1alpha1.ClusterConfig{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(cc), stored); err != nil {
+		t.Fatal(err)
+	}
+	condition := meta.FindStatusCondition(stored.Status.Conditions, clusterconfigv1alpha1.ConditionDegraded)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != ReasonSyncFailed {
+		t.Errorf("expected Degraded condition with reason %s, got %+v", ReasonSyncFailed, condition)
+	}
+	for _, namespace := range []string{"ns1", "ns2"} {
+		status := findNamespaceStatus(stored, namespace)
+		if status == nil || status.Phase != clusterconfigv1alpha1.NamespacePhasePending {
+			t.Errorf("expected %s to stay Pending, got %+v", namespace, status)
+		}
+		assertNotFound(t, c, namespace, "test")
+	}
+}
+
+// 未知的 deletionPolicy（例如大小写错误）不能按默认的 Delete 处理，资源对象保留并设置 Degraded condition
+func TestReconcileRejectsUnknownDeletionPolicy(t *testing.T) {
+	for _, deleting := range []bool{false, true} {
+		t.Run(fmt.Sprintf("deleting=%v", deleting), func(t *testing.T) {
+			cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+			cc.Spec.NamespaceList = "ns1"
+			cc.Spec.DeletionPolicy = "orphan"
+			if deleting {
+				now := metav1.Now()
+				cc.DeletionTimestamp = &now
+			}
+			// ns2 已不是目标，资源对象本应按 deletionPolicy 清理
+			r, c := newTestController(t, cc,
+				&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+				newTestCopy(cc, "ns1", true),
+				newTestCopy(cc, "ns2", true),
+			)
+
+			_, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: client.ObjectKeyFromObject(cc)})
+			if err == nil {
+				t.Fatal("expected error for unknown deletionPolicy")
+			}
+			for _, namespace := range []string{"ns1", "ns2"} {
+				if err := c.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: "test"}, &v1.ConfigMap{}); err != nil {
+					t.Errorf("expected copy in %s to be kept: %v", namespace, err)
+				}
+			}
+			stored := &clusterconfigv1alpha1.ClusterConfig{}
+			if err := c.Get(context.Background(), client.ObjectKeyFromObject(cc), stored); err != nil {
+				t.Fatal(err)
+			}
+			condition := meta.FindStatusCondition(stored.Status.Conditions, clusterconfigv1alpha1.ConditionDegraded)
+			if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != ReasonInvalidPolicy {
+				t.Errorf("expected Degraded condition with reason %s, got %+v", ReasonInvalidPolicy, condition)
+			}
+			if len(stored.Finalizers) == 0 {
+				t.Error("expected finalizer to be kept")
+			}
+		})
+	}
+}
+
+// 旧版本以 namespace 命名的 Finalizer 只在对应 namespace 的资源对象被接管或清理后移除，
+// 不能接管的资源对象保留 Finalizer，删除 ClusterConfig 时仍能找到
+func TestReconcileKeepsLegacyFinalizersUntilHandled(t *testing.T) {
+	cc := newTestClusterConfig("ns1", "ns2", "ns3")
+	cc.Spec.NamespaceList = "ns1,ns2"
+	cc.Status.ProcessedNamespace = []string{"ns1", "ns2", "ns3"}
+	// ns1 内容一致可以接管，ns2 被手动修改过不能接管，ns3 已不是目标
+	conflicting := newTestCopy(cc, "ns2", false)
+	conflicting.Data["key"] = "edited"
+	r, c := newTestController(t, cc,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns3"}},
+		newTestCopy(cc, "ns1", false),
+		conflicting,
+		newTestCopy(cc, "ns3", false),
+	)
+	req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(cc)}
+
+	// 失败后重新入列，第二次调协移除已接管的 ns1 的 Finalizer
+	for i := 0; i < 2; i++ {
+		if _, err := r.Reconcile(context.Background(), req); err == nil {
+			t.Fatal("expected error for copy that can not be adopted")
+		}
+	}
+	assertNotFound(t, c, "ns3", "test")
+	adopted := &v1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns1", Name: "test"}, adopted); err != nil {
+		t.Fatal(err)
+	}
+	if !isManagedBy(adopted, cc) {
+		t.Errorf("expected copy in ns1 to be adopted, got labels %v", adopted.Labels)
+	}
+	stored := &clusterconfigv1alpha1.ClusterConfig{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(cc), stored); err != nil {
+		t.Fatal(err)
+	}
+	if got := legacyFinalizers(stored); len(got) != 1 || got[0] != "ns2" {
+		t.Errorf("expected only legacy finalizer ns2 to be kept, got %v", got)
+	}
+
+	now := metav1.Now()
+	stored.DeletionTimestamp = &now
+	if err := c.Update(context.Background(), stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	assertNotFound(t, c, "ns2", "test")
+}
+
+// 旧版本使用 namespaceList: all 时只有 all Finalizer，status 中只记录 all，资源对象在所有 namespace 中且没有 label
+func TestReconcileMigratesLegacyAllNamespaces(t *testing.T) {
+	cc := newTestClusterConfig(allNamespace)
+	cc.Spec.NamespaceList = allNamespace
+	cc.Status.ProcessedNamespace = []string{allNamespace}
+	conflicting := newTestCopy(cc, "ns2", false)
+	conflicting.Data["key"] = "edited"
+	r, c := newTestController(t, cc,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}},
+		newTestCopy(cc, "ns1", false),
+		conflicting,
+	)
+	recorder := r.EventRecorder.(*record.FakeRecorder)
+	req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(cc)}
+
+	if _, err := r.Reconcile(context.Background(), req); err == nil {
+		t.Fatal("expected error for copy that can not be adopted")
+	}
+	for _, event := range drainEvents(recorder) {
+		if strings.Contains(event, string(EventReasonNamespaceRemoved)) {
+			t.Errorf("unexpected event %q", event)
+		}
+	}
+	adopted := &v1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns1", Name: "test"}, adopted); err != nil {
+		t.Fatal(err)
+	}
+	if !isManagedBy(adopted, cc) {
+		t.Errorf("expected copy in ns1 to be adopted, got labels %v", adopted.Labels)
+	}
+	stored := &clusterconfigv1alpha1.ClusterConfig{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(cc), stored); err != nil {
+		t.Fatal(err)
+	}
+	if !controllerutil.ContainsFinalizer(stored, allNamespace) {
+		t.Errorf("expected legacy finalizer all to be kept, got %v", stored.Finalizers)
+	}
+
+	now := metav1.Now()
+	stored.DeletionTimestamp = &now
+	if err := c.Update(context.Background(), stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Reconcile(context.Background(), req); err != nil {
+		t.Fatal(err)
+	}
+	assertNotFound(t, c, "ns1", "test")
+	assertNotFound(t, c, "ns2", "test")
+}
+
+// namespaceList 从旧版本的 all 改为具体 namespace 后，不再是目标的旧版本资源对象按 deletionPolicy 清理，all Finalizer 随后移除
+func TestReconcileCleansLegacyAllNamespaces(t *testing.T) {
+	cc := newTestClusterConfig(allNamespace)
+	cc.Spec.NamespaceList = "ns1"
+	cc.Status.ProcessedNamespace = []string{allNamespace}
+	r, c := newTestController(t, cc,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}},
+		newTestCopy(cc, "ns1", false),
+		newTestCopy(cc, "ns2", false),
+	)
+
+	if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: client.ObjectKeyFromObject(cc)}); err != nil {
+		t.Fatal(err)
+	}
+	assertNotFound(t, c, "ns2", "test")
+	stored := &clusterconfigv1alpha1.ClusterConfig{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(cc), stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Finalizers) != 1 || stored.Finalizers[0] != clusterconfigv1alpha1.ClusterConfigFinalizer {
+		t.Errorf("expected only %s, got %v", clusterconfigv1alpha1.ClusterConfigFinalizer, stored.Finalizers)
+	}
+	if !reflect.DeepEqual(stored.Status.ProcessedNamespace, []string{"ns1"}) {
+		t.Errorf("expected processedNamespace [ns1], got %v", stored.Status.ProcessedNamespace)
+	}
+}
+
+// 所有旧版本资源对象都已接管时，在同一次调协中移除旧版本的 Finalizer
+func TestReconcileRemovesLegacyFinalizersAfterAdoption(t *testing.T) {
+	cc := newTestClusterConfig("ns1")
+	cc.Spec.NamespaceList = "ns1"
+	cc.Status.ProcessedNamespace = []string{"ns1"}
+	r, c := newTestController(t, cc,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		newTestCopy(cc, "ns1", false),
+	)
+
+	if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: client.ObjectKeyFromObject(cc)}); err != nil {
+		t.Fatal(err)
+	}
+	stored := &clusterconfigv1alpha1.ClusterConfig{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(cc), stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Finalizers) != 1 || stored.Finalizers[0] != clusterconfigv1alpha1.ClusterConfigFinalizer {
+		t.Errorf("expected only %s, got %v", clusterconfigv1alpha1.ClusterConfigFinalizer, stored.Finalizers)
+	}
+}
+
+// 名称超过 label value 长度限制的 ClusterConfig 直接拒绝，不尝试写入资源对象
+func TestReconcileRejectsNameTooLongForLabel(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	cc.Name = strings.Repeat("a", 64)
+	cc.Spec.NamespaceList = "ns1"
+	r, c := newTestController(t, cc, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}})
+
+	if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: client.ObjectKeyFromObject(cc)}); err == nil {
+		t.Fatal("expected error for name longer than 63 characters")
+	}
+	stored := &clusterconfigv1alpha1.ClusterConfig{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(cc), stored); err != nil {
+		t.Fatal(err)
+	}
+	condition := meta.FindStatusCondition(stored.Status.Conditions, clusterconfigv1alpha1.ConditionDegraded)
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Reason != ReasonInvalidName {
+		t.Errorf("expected Degraded condition with reason %s, got %+v", ReasonInvalidName, condition)
+	}
+	assertNotFound(t, c, "ns1", cc.Name)
+}
+
+func TestPolicyDefaultsValidate(t *testing.T) {
+	tests := []struct {
+		policies PolicyDefaults
+		wantErr  bool
+	}{
+		{policies: PolicyDefaults{}},
+		{policies: PolicyDefaults{AdoptionPolicy: clusterconfigv1alpha1.AdoptionPolicyIfMatching, DeletionPolicy: clusterconfigv1alpha1.DeletionPolicyRetain, DriftPolicy: clusterconfigv1alpha1.DriftPolicyWarn}},
+		{policies: PolicyDefaults{AdoptionPolicy: "always"}, wantErr: true},
+		{policies: PolicyDefaults{DeletionPolicy: "Orphaned"}, wantErr: true},
+		{policies: PolicyDefaults{DriftPolicy: "warn"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.policies.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("%+v: expected error %v, got %v", tt.policies, tt.wantErr, err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package controller
+
+import (
+	"fmt"
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	"github.com/myoperator/clusterconfigoperator/pkg/metrics"
+	"k8s.io/klog/v2"
+)
+
+// errDrifted 资源对象被手动修改，driftPolicy 为 Warn 时保留修改
+var errDrifted = fmt.Errorf("drifted")
+
+func (r *ClusterConfigController) driftPolicy(clusterConfig *clusterconfigv1alpha1.ClusterConfig) clusterconfigv1alpha1.DriftPolicy {
+	if clusterConfig.Spec.DriftPolicy != "" {
+		return clusterConfig.Spec.DriftPolicy
+	}
+	if r.PolicyDefaults.DriftPolicy != "" {
+		return r.PolicyDefaults.DriftPolicy
+	}
+	return clusterconfigv1alpha1.DriftPolicyEnforce
+}
+
+// isDrift 判断 namespace 中的资源对象与期望不一致是否由手动修改导致：
+// 上一次同步的内容与当前期望一致（spec 未变更），资源对象却被修改或删除
+func isDrift(clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespace, hash string) bool {
+	status := findNamespaceStatus(clusterConfig, namespace)
+	if status == nil || status.ContentHash == "" || status.ContentHash != hash {
+		return false
+	}
+	return status.Phase == clusterconfigv1alpha1.NamespacePhaseSynced || status.Phase == clusterconfigv1alpha1.NamespacePhaseDrifted
+}
+
+// handleDrift 按 driftPolicy 处理漂移，返回是否需要恢复为期望内容，Warn 时返回 errDrifted，
+// 事件在所有 namespace 同步完成后统一记录
+func (r *ClusterConfigController) handleDrift(clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespace, detail string) (bool, error) {
+	policy := r.driftPolicy(clusterConfig)
+	metrics.DriftDetected.WithLabelValues(clusterConfig.Namespace, clusterConfig.Name, namespace, string(policy)).Inc()
+
+	name := targetName(clusterConfig)
+	switch policy {
+	case clusterconfigv1alpha1.DriftPolicyIgnore:
+		klog.Infof("[%s] %s/%s %s, driftPolicy is Ignore\n", clusterConfig.Spec.ConfigType, namespace, name, detail)
+		return false, nil
+	case clusterconfigv1alpha1.DriftPolicyWarn:
+		return false, fmt.Errorf("%w: %s %s/%s %s", errDrifted, clusterConfig.Spec.ConfigType, namespace, name, detail)
+	default:
+		klog.Infof("[%s] %s/%s %s, revert it\n", clusterConfig.Spec.ConfigType, namespace, name, detail)
+		return true, nil
+	}
+}
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"context"
+	stderrors "errors"
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"testing"
+)
+
+// 上一次同步后资源对象被手动修改，按 driftPolicy 处理
+func TestSyncConfigMapDrift(t *testing.T) {
+	tests := []struct {
+		policy    clusterconfigv1alpha1.DriftPolicy
+		wantValue string
+		wantErr   error
+	}{
+		{policy: "", wantValue: "value"},
+		{policy: clusterconfigv1alpha1.DriftPolicyEnforce, wantValue: "value"},
+		{policy: clusterconfigv1alpha1.DriftPolicyWarn, wantValue: "edited", wantErr: errDrifted},
+		{policy: clusterconfigv1alpha1.DriftPolicyIgnore, wantValue: "edited"},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+			cc.Spec.DriftPolicy = tt.policy
+			hash := newConfigMap(cc, "").Annotations[clusterconfigv1alpha1.AnnotationContentHash]
+			setNamespaceStatus(cc, "ns1", hash, nil)
+
+			cm := newTestCopy(cc, "ns1", true)
+			cm.Data["key"] = "edited"
+			r, c := newTestController(t, cc, cm)
+
+			_, err := r.syncConfigMap(context.Background(), cc, "ns1", hash)
+			if !stderrors.Is(err, tt.wantErr) {
+				t.Fatalf("expected err %v, got %v", tt.wantErr, err)
+			}
+			got := &v1.ConfigMap{}
+			if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns1", Name: "test"}, got); err != nil {
+				t.Fatal(err)
+			}
+			if got.Data["key"] != tt.wantValue {
+				t.Errorf("expected data %q, got %q", tt.wantValue, got.Data["key"])
+			}
+		})
+	}
+}
+
+// spec 变更后内容不一致不是漂移，总是更新
+func TestSyncConfigMapSpecChangeIsNotDrift(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	cc.Spec.DriftPolicy = clusterconfigv1alpha1.DriftPolicyIgnore
+	setNamespaceStatus(cc, "ns1", "previous-hash", nil)
+	r, c := newTestController(t, cc, newTestCopy(cc, "ns1", true))
+
+	cc.Spec.Data["key"] = "changed"
+	hash := newConfigMap(cc, "").Annotations[clusterconfigv1alpha1.AnnotationContentHash]
+	if _, err := r.syncConfigMap(context.Background(), cc, "ns1", hash); err != nil {
+		t.Fatal(err)
+	}
+	got := &v1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns1", Name: "test"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Data["key"] != "changed" {
+		t.Errorf("expected data to follow spec, got %q", got.Data["key"])
+	}
+}
+
+// 未知的 driftPolicy（例如 warn）不能按 Enforce 处理而覆盖手动修改
+func TestReconcileRejectsUnknownDriftPolicy(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	cc.Spec.NamespaceList = "ns1"
+	cc.Spec.DriftPolicy = "warn"
+	hash := newConfigMap(cc, "").Annotations[clusterconfigv1alpha1.AnnotationContentHash]
+	setNamespaceStatus(cc, "ns1", hash, nil)
+	cm := newTestCopy(cc, "ns1", true)
+	cm.Data["key"] = "edited"
+	r, c := newTestController(t, cc, &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}}, cm)
+
+	if _, err := r.Reconcile(context.Background(), reconcile.Request{NamespacedName: client.ObjectKeyFromObject(cc)}); err == nil {
+		t.Fatal("expected error for unknown driftPolicy")
+	}
+	got := &v1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns1", Name: "test"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Data["key"] != "edited" {
+		t.Errorf("expected manual change to be kept, got %q", got.Data["key"])
+	}
+}
@@ -0,0 +1,115 @@
+package controller
+
+import (
+	"fmt"
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	"github.com/myoperator/clusterconfigoperator/pkg/common"
+	v1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"strings"
+)
+
+// EventReason ClusterConfig 上记录的事件的 Reason，失败事件使用 Condition 的 Reason
+type EventReason string
+
+const (
+	// EventReasonCreated 资源对象已创建
+	EventReasonCreated EventReason = "Created"
+	// EventReasonUpdated 资源对象已更新为期望内容
+	EventReasonUpdated EventReason = "Updated"
+	// EventReasonDeleted 不再需要的资源对象已删除
+	EventReasonDeleted EventReason = "Deleted"
+	// EventReasonDriftDetected 资源对象被手动修改，driftPolicy 为 Warn 时保留修改
+	EventReasonDriftDetected EventReason = "DriftDetected"
+	// EventReasonDriftReverted 被手动修改的资源对象已恢复为期望内容
+	EventReasonDriftReverted EventReason = "DriftReverted"
+	// EventReasonConflictSkipped 资源对象由其他 ClusterConfig 或其他人管理，跳过该 namespace
+	EventReasonConflictSkipped EventReason = "ConflictSkipped"
+	// EventReasonNamespaceAdded namespace 成为下发目标
+	EventReasonNamespaceAdded EventReason = "NamespaceAdded"
+	// EventReasonNamespaceRemoved namespace 不再是下发目标或已删除
+	EventReasonNamespaceRemoved EventReason = "NamespaceRemoved"
+)
+
+// eventReasons 同一次调协中事件的记录顺序
+var eventReasons = []EventReason{
+	EventReasonNamespaceRemoved,
+	EventReasonDeleted,
+	EventReasonNamespaceAdded,
+	EventReasonCreated,
+	EventReasonUpdated,
+	EventReasonDriftReverted,
+	EventReasonDriftDetected,
+	EventReasonConflictSkipped,
+}
+
+// eventMessages 事件内容，依次填入资源对象的类型、名称与 namespace 列表
+var eventMessages = map[EventReason]string{
+	EventReasonCreated:          "%s %s created in %s",
+	EventReasonUpdated:          "%s %s updated in %s",
+	EventReasonDeleted:          "%s %s deleted in %s",
+	EventReasonDriftDetected:    "%s %s was changed outside the operator and kept by driftPolicy Warn in %s",
+	EventReasonDriftReverted:    "%s %s was changed outside the operator and reverted in %s",
+	EventReasonConflictSkipped:  "%s %s is managed by others, skipped %s",
+	EventReasonNamespaceAdded:   "%s %s will be synced to %s",
+	EventReasonNamespaceRemoved: "%s %s is no longer synced to %s",
+}
+
+// maxEventNamespaces 单个事件中最多列出的 namespace 数量
+const maxEventNamespaces = 10
+
+func (reason EventReason) eventType() string {
+	switch reason {
+	case EventReasonDriftDetected, EventReasonConflictSkipped:
+		return v1.EventTypeWarning
+	default:
+		return v1.EventTypeNormal
+	}
+}
+
+// namespaceEvents 按 Reason 收集一次调协中涉及的 namespace，相同 Reason 合并为一个事件，
+// 避免 namespace 较多时超出单个对象的事件限流，导致后续的失败事件被丢弃
+type namespaceEvents map[EventReason][]string
+
+func (e namespaceEvents) add(reason EventReason, namespace string) {
+	e[reason] = append(e[reason], namespace)
+}
+
+// recordNamespaceEvents 记录收集到的事件，kind name 为下发的资源对象
+func (r *ClusterConfigController) recordNamespaceEvents(clusterConfig *clusterconfigv1alpha1.ClusterConfig, kind, name string, events namespaceEvents) {
+	for _, reason := range eventReasons {
+		namespaces := events[reason]
+		if len(namespaces) == 0 {
+			continue
+		}
+		r.EventRecorder.Eventf(clusterConfig, reason.eventType(), string(reason), eventMessages[reason], kind, name, formatNamespaces(namespaces))
+	}
+}
+
+// formatNamespaces ex: namespace ns1；namespaces ns1, ns2 and 3 more
+func formatNamespaces(namespaces []string) string {
+	namespaces = uniqueSorted(namespaces)
+	if len(namespaces) == 1 {
+		return "namespace " + namespaces[0]
+	}
+	if len(namespaces) <= maxEventNamespaces {
+		return "namespaces " + strings.Join(namespaces, ", ")
+	}
+	return fmt.Sprintf("namespaces %s and %d more", strings.Join(namespaces[:maxEventNamespaces], ", "), len(namespaces)-maxEventNamespaces)
+}
+
+// targetKind 下发的资源对象类型
+func targetKind(clusterConfig *clusterconfigv1alpha1.ClusterConfig) string {
+	if clusterConfig.Spec.ConfigType == common.Secrets {
+		return "Secret"
+	}
+	return "ConfigMap"
+}
+
+// copyKind 资源对象的类型，typed 对象的 TypeMeta 通常为空
+func copyKind(obj client.Object) string {
+	if _, ok := obj.(*v1.Secret); ok {
+		return "Secret"
+	}
+	return "ConfigMap"
+}
@@ -0,0 +1,89 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"testing"
+)
+
+func drainEvents(recorder *record.FakeRecorder) []string {
+	events := make([]string, 0)
+	for {
+		select {
+		case event := <-recorder.Events:
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+// 调协成功时按 Reason 合并记录 Normal 事件，事件中包含 namespace
+func TestReconcileRecordsLifecycleEvents(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	cc.Spec.NamespaceList = "ns1,ns2"
+	r, c := newTestController(t, cc,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}},
+	)
+	recorder := r.EventRecorder.(*record.FakeRecorder)
+	req := reconcile.Request{NamespacedName: client.ObjectKeyFromObject(cc)}
+	reconcileAndExpect := func(want ...string) {
+		t.Helper()
+		if _, err := r.Reconcile(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+		got := drainEvents(recorder)
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("expected events %q, got %q", want, got)
+		}
+	}
+
+	reconcileAndExpect(
+		"Normal NamespaceAdded ConfigMap test will be synced to namespaces ns1, ns2",
+		"Normal Created ConfigMap test created in namespaces ns1, ns2",
+	)
+
+	// 没有变化时不记录事件
+	reconcileAndExpect()
+
+	if err := c.Get(context.Background(), req.NamespacedName, cc); err != nil {
+		t.Fatal(err)
+	}
+	cc.Spec.NamespaceList = "ns1"
+	cc.Spec.Data["key"] = "changed"
+	if err := c.Update(context.Background(), cc); err != nil {
+		t.Fatal(err)
+	}
+	reconcileAndExpect(
+		"Normal Deleted ConfigMap test deleted in namespace ns2",
+		"Normal NamespaceRemoved ConfigMap test is no longer synced to namespace ns2",
+		"Normal Updated ConfigMap test updated in namespace ns1",
+	)
+}
+
+func TestFormatNamespaces(t *testing.T) {
+	many := make([]string, 0, 12)
+	for i := 0; i < 12; i++ {
+		many = append(many, fmt.Sprintf("ns%02d", i))
+	}
+	tests := []struct {
+		namespaces []string
+		want       string
+	}{
+		{[]string{"ns1"}, "namespace ns1"},
+		{[]string{"ns2", "ns1", "ns2"}, "namespaces ns1, ns2"},
+		{many, "namespaces ns00, ns01, ns02, ns03, ns04, ns05, ns06, ns07, ns08, ns09 and 2 more"},
+	}
+	for _, tt := range tests {
+		if got := formatNamespaces(tt.namespaces); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
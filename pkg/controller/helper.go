@@ -1,77 +1,100 @@
 package controller
 
 import (
+	"bytes"
 	"context"
+	stderrors "errors"
+	"fmt"
 	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
 	"github.com/myoperator/clusterconfigoperator/pkg/common"
+	"github.com/myoperator/clusterconfigoperator/pkg/metrics"
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/csaupgrade"
+	"k8s.io/client-go/util/workqueue"
 	"k8s.io/klog/v2"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sort"
 	"strings"
+	"time"
 )
 
+// FieldManager 写入下发资源对象时使用的字段管理者名称
+const FieldManager = "clusterconfig-operator"
+
 // deleteResource 清理资源对象逻辑
+// 按 label 列出所有 namespace 下由该 ClusterConfig 管理的资源对象，并按 deletionPolicy 处理，不依赖当前的 spec，
+// 因此创建后修改过 namespaceList targetName configType 也能清理干净。
+// 每个 namespace 都会尝试处理，资源对象不存在视为成功，错误在最后一并返回；
+// 旧版本以 namespace 命名的 Finalizer 在该 namespace 处理成功后立即移除，全部成功后再移除 Finalizer
 func (r *ClusterConfigController) deleteResource(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig) error {
-	// 1. 先分割出目标 namespace
-	namespaceList := splitString(clusterConfig.Spec.NamespaceList, ",")
-
-	// 处理 namespace 字段为 "all"时的逻辑
-	if len(namespaceList) == 1 && namespaceList[0] == "all" {
-		return r.deleteResourceForAllNamespace(ctx, clusterConfig)
+	copies, err := r.listManagedCopies(ctx, clusterConfig)
+	if err != nil {
+		return err
 	}
+	legacyCopies, err := r.listLegacyCopies(ctx, clusterConfig)
+	if err != nil {
+		return err
+	}
+	copies = append(copies, legacyCopies...)
 
-	// 2. 遍历 namespace
-	// 先去各个 namespace 查找是否存在，
-	// 如果不存在，则创建，
-	// 如果已经存在，则比较 data 字段是否一致，如果不一致则修改
-	// FIXME: 注意这里会有一种情况：就是修改 namespaceList 结果该删除的未删除的情况
-	for _, namespace := range namespaceList {
+	byNamespace := make(map[string][]client.Object)
+	namespaces := legacyFinalizers(clusterConfig)
+	for _, obj := range copies {
+		byNamespace[obj.GetNamespace()] = append(byNamespace[obj.GetNamespace()], obj)
+		namespaces = append(namespaces, obj.GetNamespace())
+	}
 
-		switch clusterConfig.Spec.ConfigType {
-		case common.ConfigMaps:
-			toConfigMap := &v1.ConfigMap{}
-			err := r.client.Get(ctx, client.ObjectKey{Name: clusterConfig.Name, Namespace: namespace}, toConfigMap)
+	errs := make([]error, 0)
+	released := make([]client.Object, 0, len(copies))
+	finalizerChanged := false
+	for _, namespace := range uniqueSorted(namespaces, allNamespace) {
+		failed := false
+		for _, obj := range byNamespace[namespace] {
+			ok, err := r.releaseCopy(ctx, clusterConfig, obj)
 			if err != nil {
-				if errors.IsNotFound(err) {
-
-					klog.Info("[toConfigMap] notfound ")
-					return nil
-				}
-				klog.Error(err, "[toConfigMap] Failed to get")
-				return err
+				errs = append(errs, fmt.Errorf("namespace %s: %w", namespace, err))
+				failed = true
+				continue
 			}
-			err = r.client.Delete(ctx, toConfigMap)
-			if err != nil {
-				klog.Error(err, "[toConfigMap] Failed to delete")
-				return err
+			if ok {
+				released = append(released, obj)
 			}
-		case common.Secrets:
-			toSecret := &v1.Secret{}
-			err := r.client.Get(ctx, client.ObjectKey{Name: clusterConfig.Name, Namespace: namespace}, toSecret)
-			if err != nil {
-				if errors.IsNotFound(err) {
+		}
+		// 该 namespace 处理完成，移除旧版本以 namespace 命名的 Finalizer
+		if !failed && controllerutil.ContainsFinalizer(clusterConfig, namespace) {
+			controllerutil.RemoveFinalizer(clusterConfig, namespace)
+			finalizerChanged = true
+		}
+	}
 
-					klog.Info("[toSecret] notfound ")
-					return nil
-				}
-				klog.Error(err, "[toSecret] Failed to get")
-				return err
-			}
-			err = r.client.Delete(ctx, toSecret)
+	r.recordReleasedCopies(clusterConfig, released)
+
+	// 有 namespace 处理失败时保留 Finalizer，已处理完成的 namespace 的 Finalizer 先移除
+	if len(errs) != 0 {
+		if finalizerChanged {
+			err = r.client.Update(ctx, clusterConfig)
 			if err != nil {
-				klog.Error(err, "[toSecret] Failed to delete")
-				return err
+				errs = append(errs, err)
 			}
 		}
+		return utilerrors.NewAggregate(errs)
+	}
 
-		// 清理完成后，从 Finalizers 中移除 Finalizer
-		controllerutil.RemoveFinalizer(clusterConfig, namespace)
-		err := r.client.Update(ctx, clusterConfig)
+	// 清理完成后，从 Finalizers 中移除 Finalizer
+	if removeFinalizers(clusterConfig) {
+		err = r.client.Update(ctx, clusterConfig)
 		if err != nil {
 			klog.Error("clean clusterConfig finalizer err: ", err)
 			return err
@@ -81,423 +104,780 @@ func (r *ClusterConfigController) deleteResource(ctx context.Context, clusterCon
 	return nil
 }
 
-func (r *ClusterConfigController) deleteResourceByNamespace(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespaceList []string) error {
+// cleanupStaleCopies 按 deletionPolicy 处理不再需要的资源对象：所在 namespace 已不是目标，或者 targetName configType 已变更，
+// 返回删除或解除管理了资源对象的 namespace。单个资源对象失败不影响其他资源对象，错误在最后一并返回
+func (r *ClusterConfigController) cleanupStaleCopies(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespaceList []string) ([]string, error) {
+	copies, err := r.listManagedCopies(ctx, clusterConfig)
+	if err != nil {
+		return nil, err
+	}
+	// 旧版本创建的资源对象没有 label，按旧版本的 Finalizer 找到后一并处理，避免 namespace 不再是目标时遗留
+	legacyCopies, err := r.listLegacyCopies(ctx, clusterConfig)
+	if err != nil {
+		return nil, err
+	}
+	copies = append(copies, legacyCopies...)
+
+	cleaned := make([]string, 0)
+	released := make([]client.Object, 0)
+	errs := make([]error, 0)
+	for _, obj := range copies {
+		if !isStaleCopy(clusterConfig, obj, namespaceList) {
+			continue
+		}
+		ok, err := r.releaseCopy(ctx, clusterConfig, obj)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("namespace %s: %w", obj.GetNamespace(), err))
+			continue
+		}
+		if ok {
+			cleaned = append(cleaned, obj.GetNamespace())
+			released = append(released, obj)
+		}
+	}
+	r.recordReleasedCopies(clusterConfig, released)
+
+	return uniqueSorted(cleaned), utilerrors.NewAggregate(errs)
+}
 
-	// 2. 遍历 namespace
-	// 先去各个 namespace 查找是否存在，
-	// 如果不存在，则创建，
-	// 如果已经存在，则比较 data 字段是否一致，如果不一致则修改
-	// FIXME: 注意这里会有一种情况：就是修改 namespaceList 结果该删除的未删除的情况
-	for _, namespace := range namespaceList {
+// recordReleasedCopies deletionPolicy 为 Delete 时，按资源对象的类型与名称记录 Deleted 事件
+func (r *ClusterConfigController) recordReleasedCopies(clusterConfig *clusterconfigv1alpha1.ClusterConfig, released []client.Object) {
+	if r.deletionPolicy(clusterConfig) != clusterconfigv1alpha1.DeletionPolicyDelete {
+		return
+	}
+	byCopy := make(map[[2]string][]string)
+	for _, obj := range released {
+		key := [2]string{copyKind(obj), obj.GetName()}
+		byCopy[key] = append(byCopy[key], obj.GetNamespace())
+	}
+	for key, namespaces := range byCopy {
+		r.recordNamespaceEvents(clusterConfig, key[0], key[1], namespaceEvents{EventReasonDeleted: namespaces})
+	}
+}
 
+// listLegacyCopies 旧版本创建的资源对象没有 label，按旧版本以 namespace 命名的 Finalizer 找到对应 namespace 下的同名资源对象
+func (r *ClusterConfigController) listLegacyCopies(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig) ([]client.Object, error) {
+	namespaces, err := r.legacyNamespaces(ctx, clusterConfig)
+	if err != nil {
+		return nil, err
+	}
+	copies := make([]client.Object, 0)
+	for _, namespace := range namespaces {
+		var obj client.Object
 		switch clusterConfig.Spec.ConfigType {
 		case common.ConfigMaps:
-			toConfigMap := &v1.ConfigMap{}
-			err := r.client.Get(ctx, client.ObjectKey{Name: clusterConfig.Name, Namespace: namespace}, toConfigMap)
-			if err != nil {
-				if errors.IsNotFound(err) {
-
-					klog.Info("[toConfigMap] notfound ")
-					return nil
-				}
-				klog.Error(err, "[toConfigMap] Failed to get")
-				return err
-			}
-			err = r.client.Delete(ctx, toConfigMap)
-			if err != nil {
-				klog.Error(err, "[toConfigMap] Failed to delete")
-				return err
-			}
+			obj = &v1.ConfigMap{}
 		case common.Secrets:
-			toSecret := &v1.Secret{}
-			err := r.client.Get(ctx, client.ObjectKey{Name: clusterConfig.Name, Namespace: namespace}, toSecret)
-			if err != nil {
-				if errors.IsNotFound(err) {
-
-					klog.Info("[toSecret] notfound ")
-					return nil
-				}
-				klog.Error(err, "[toSecret] Failed to get")
-				return err
-			}
-			err = r.client.Delete(ctx, toSecret)
-			if err != nil {
-				klog.Error(err, "[toSecret] Failed to delete")
-				return err
+			obj = &v1.Secret{}
+		default:
+			continue
+		}
+		err := r.getCopy(ctx, client.ObjectKey{Name: targetName(clusterConfig), Namespace: namespace}, obj)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
 			}
+			return nil, err
+		}
+		// 带 label 的资源对象已按 label 列出，或者属于其他 ClusterConfig
+		if !isManaged(obj) {
+			copies = append(copies, obj)
 		}
+	}
+	return copies, nil
+}
 
-		// 清理完成后，从 Finalizers 中移除 Finalizer
-		controllerutil.RemoveFinalizer(clusterConfig, namespace)
-		err := r.client.Update(ctx, clusterConfig)
+// legacyNamespaces 旧版本 Finalizer 记录的 namespace，all 代表旧版本在所有 namespace 中都创建了资源对象
+func (r *ClusterConfigController) legacyNamespaces(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig) ([]string, error) {
+	namespaces := legacyFinalizers(clusterConfig)
+	if controllerutil.ContainsFinalizer(clusterConfig, allNamespace) {
+		namespaceList := &v1.NamespaceList{}
+		err := r.client.List(ctx, namespaceList)
 		if err != nil {
-			klog.Error("clean clusterConfig finalizer err: ", err)
-			return err
+			return nil, err
+		}
+		for _, namespace := range namespaceList.Items {
+			namespaces = append(namespaces, namespace.Name)
 		}
 	}
+	return uniqueSorted(namespaces, allNamespace), nil
+}
 
-	return nil
+// legacyCopyNamespaces 仍有未接管的旧版本资源对象的 namespace，对应的 Finalizer 需要保留；
+// 没有以该 namespace 命名的 Finalizer 时，资源对象由 all Finalizer 记录
+func (r *ClusterConfigController) legacyCopyNamespaces(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig) ([]string, error) {
+	copies, err := r.listLegacyCopies(ctx, clusterConfig)
+	if err != nil {
+		return nil, err
+	}
+	namespaces := make([]string, 0, len(copies))
+	for _, obj := range copies {
+		namespace := obj.GetNamespace()
+		if !controllerutil.ContainsFinalizer(clusterConfig, namespace) {
+			namespace = allNamespace
+		}
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces, nil
 }
 
-// deleteResource 清理资源对象逻辑
-// FIXME: 可以抽象出来，冗于代码太多了
-func (r *ClusterConfigController) deleteResourceForAllNamespace(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig) error {
-	klog.Infof("delete cluster config for all namespace...")
-	clusterNamespaceList := v1.NamespaceList{}
-	err := r.client.List(ctx, &clusterNamespaceList)
+// releaseCopy 按 deletionPolicy 处理不再需要的资源对象，返回资源对象是否被删除或解除管理
+// Delete：删除；Orphan：移除管理 label 后保留；Retain：原样保留，不再更新
+func (r *ClusterConfigController) releaseCopy(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig, obj client.Object) (bool, error) {
+	switch r.deletionPolicy(clusterConfig) {
+	case clusterconfigv1alpha1.DeletionPolicyRetain:
+		klog.Infof("[%T] %s/%s is retained\n", obj, obj.GetNamespace(), obj.GetName())
+		return false, nil
+	case clusterconfigv1alpha1.DeletionPolicyOrphan:
+		// 只移除管理 label，使用 merge patch 避免覆盖其他字段
+		original := obj.DeepCopyObject().(client.Object)
+		labels := obj.GetLabels()
+		for key := range ownerLabels(clusterConfig) {
+			delete(labels, key)
+		}
+		obj.SetLabels(labels)
+		err := r.client.Patch(ctx, obj, client.MergeFrom(original), client.FieldOwner(FieldManager))
+		if client.IgnoreNotFound(err) != nil {
+			klog.Errorf("[%T] %s/%s Failed to orphan error: %v\n", obj, obj.GetNamespace(), obj.GetName(), err)
+			return false, err
+		}
+		klog.Infof("[%T] %s/%s is orphaned\n", obj, obj.GetNamespace(), obj.GetName())
+		return true, nil
+	case clusterconfigv1alpha1.DeletionPolicyDelete:
+		err := r.client.Delete(ctx, obj)
+		if client.IgnoreNotFound(err) != nil {
+			klog.Errorf("[%T] %s/%s Failed to delete error: %v\n", obj, obj.GetNamespace(), obj.GetName(), err)
+			return false, err
+		}
+		klog.Infof("[%T] %s/%s deleted\n", obj, obj.GetNamespace(), obj.GetName())
+		return true, nil
+	default:
+		// 未知的策略不删除，调协开始时已经校验过
+		return false, fmt.Errorf("unknown deletionPolicy %q", r.deletionPolicy(clusterConfig))
+	}
+}
+
+func (r *ClusterConfigController) deletionPolicy(clusterConfig *clusterconfigv1alpha1.ClusterConfig) clusterconfigv1alpha1.DeletionPolicy {
+	if clusterConfig.Spec.DeletionPolicy != "" {
+		return clusterConfig.Spec.DeletionPolicy
+	}
+	if r.PolicyDefaults.DeletionPolicy != "" {
+		return r.PolicyDefaults.DeletionPolicy
+	}
+	return clusterconfigv1alpha1.DeletionPolicyDelete
+}
+
+// listManagedCopies 按 label 列出所有 namespace 下由该 ClusterConfig 管理的 ConfigMap 与 Secret
+func (r *ClusterConfigController) listManagedCopies(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig) ([]client.Object, error) {
+	selector := client.MatchingLabels{
+		clusterconfigv1alpha1.LabelClusterConfigName:      clusterConfig.Name,
+		clusterconfigv1alpha1.LabelClusterConfigNamespace: clusterConfig.Namespace,
+	}
+
+	configMapList := &v1.ConfigMapList{}
+	err := r.client.List(ctx, configMapList, selector)
 	if err != nil {
+		return nil, err
+	}
+	secretList := &v1.SecretList{}
+	err = r.client.List(ctx, secretList, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	copies := make([]client.Object, 0, len(configMapList.Items)+len(secretList.Items))
+	for i := range configMapList.Items {
+		copies = append(copies, &configMapList.Items[i])
+	}
+	for i := range secretList.Items {
+		copies = append(copies, &secretList.Items[i])
+	}
+	return copies, nil
+}
+
+// isStaleCopy 判断资源对象是否已不再需要
+func isStaleCopy(clusterConfig *clusterconfigv1alpha1.ClusterConfig, obj client.Object, namespaceList []string) bool {
+	if !containsString(namespaceList, obj.GetNamespace()) || obj.GetName() != targetName(clusterConfig) {
+		return true
+	}
+	switch obj.(type) {
+	case *v1.ConfigMap:
+		return clusterConfig.Spec.ConfigType != common.ConfigMaps
+	case *v1.Secret:
+		return clusterConfig.Spec.ConfigType != common.Secrets
+	}
+	return false
+}
+
+// handleConfigmaps 处理 configmaps 资源对象
+// 单个 namespace 失败不影响其他 namespace，所有错误在最后一并返回
+func (r *ClusterConfigController) handleConfigmaps(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespaceList []string) error {
+	// 校验 data 与 binaryData，不满足时所有 namespace 都无法写入，直接返回
+	err := validateConfigMapData(clusterConfig)
+	if err != nil {
+		for _, namespace := range namespaceList {
+			setNamespaceStatus(clusterConfig, namespace, "", err)
+		}
 		return err
 	}
 
-	// 2. 遍历 namespace
-	// 先去各个 namespace 查找是否存在，
-	// 如果不存在，则创建，
-	// 如果已经存在，则比较 data 字段是否一致，如果不一致则修改
-	// FIXME: 注意这里会有一种情况：就是修改 namespaceList 结果该删除的未删除的情况
-	for _, namespace := range clusterNamespaceList.Items {
+	klog.Infof("namespace list: %v\n", namespaceList)
+	hash := newConfigMap(clusterConfig, "").Annotations[clusterconfigv1alpha1.AnnotationContentHash]
 
-		switch clusterConfig.Spec.ConfigType {
-		case common.ConfigMaps:
-			toConfigMap := &v1.ConfigMap{}
-			err := r.client.Get(ctx, client.ObjectKey{Name: clusterConfig.Name, Namespace: namespace.Name}, toConfigMap)
-			if err != nil {
-				if errors.IsNotFound(err) {
+	copies := &v1.ConfigMapList{}
+	return r.syncNamespaces(ctx, clusterConfig, namespaceList, hash, copies, func(namespace string) (syncResult, error) {
+		return r.syncConfigMap(ctx, clusterConfig, namespace, hash)
+	})
+}
 
-					klog.Info("[toConfigMap] notfound ")
-					return nil
-				}
-				klog.Error(err, "[toConfigMap] Failed to get")
-				return err
-			}
-			err = r.client.Delete(ctx, toConfigMap)
-			if err != nil {
-				klog.Error(err, "[toConfigMap] Failed to delete")
-				return err
+// syncConfigMap 先去 namespace 查找是否存在，
+// 如果不存在，则创建，
+// 如果已经存在，则比较 data 字段是否一致，如果不一致则修改
+// 被手动修改或删除时按 driftPolicy 处理，写入统一使用 server-side apply
+func (r *ClusterConfigController) syncConfigMap(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespace, hash string) (syncResult, error) {
+	klog.Infof("namespace to create configmaps: %v\n", namespace)
+	desired := newConfigMap(clusterConfig, namespace)
+	toConfigMap := &v1.ConfigMap{}
+	reason := EventReasonUpdated
+	err := r.getCopy(ctx, client.ObjectKey{Name: targetName(clusterConfig), Namespace: namespace}, toConfigMap)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("[toConfigMap] Failed to get in [%v] namespace, error: %v", namespace, err)
+			return syncResult{}, err
+		}
+		if isDrift(clusterConfig, namespace, hash) {
+			revert, err := r.handleDrift(clusterConfig, namespace, "was deleted")
+			if !revert {
+				return syncResult{}, err
 			}
-		case common.Secrets:
-			toSecret := &v1.Secret{}
-			err := r.client.Get(ctx, client.ObjectKey{Name: clusterConfig.Name, Namespace: namespace.Name}, toSecret)
-			if err != nil {
-				if errors.IsNotFound(err) {
+			reason = EventReasonDriftReverted
+		} else {
+			reason = EventReasonCreated
+		}
+		err = r.applyCopy(ctx, desired)
+		if err != nil {
+			klog.Errorf("[toConfigMap] in [%v] namespace Failed to create error: %v\n", namespace, err)
+			return syncResult{}, err
+		}
+		klog.Infof("[toConfigMap] Created in [%v] namespace\n", namespace)
+		return syncResult{resourceVersion: desired.ResourceVersion, reason: reason}, nil
+	}
 
-					klog.Info("[toSecret] notfound ")
-					return nil
-				}
-				klog.Error(err, "[toSecret] Failed to get")
-				return err
-			}
-			err = r.client.Delete(context.Background(), toSecret)
-			if err != nil {
-				klog.Error(err, "[toSecret] Failed to delete")
-				return err
-			}
+	// 已存在的资源对象需要确认是否可以接管
+	contentMatches := equalStringMap(toConfigMap.Data, desired.Data) && equalBytesMap(toConfigMap.BinaryData, desired.BinaryData)
+	err = r.checkAdoption(clusterConfig, toConfigMap, contentMatches)
+	if err != nil {
+		klog.Errorf("[toConfigMap] in [%v] namespace can not be adopted: %v\n", namespace, err)
+		return syncResult{}, err
+	}
+	if !contentMatches && isManagedBy(toConfigMap, clusterConfig) && isDrift(clusterConfig, namespace, hash) {
+		revert, err := r.handleDrift(clusterConfig, namespace, "was modified")
+		if !revert {
+			return syncResult{resourceVersion: toConfigMap.ResourceVersion}, err
 		}
+		reason = EventReasonDriftReverted
 	}
 
-	// 清理完成后，从 Finalizers 中移除 Finalizer
-	controllerutil.RemoveFinalizer(clusterConfig, "all")
-	err = r.client.Update(ctx, clusterConfig)
+	// Apply toConfigMap if data, binaryData or metadata is changed.
+	if contentMatches && !needsApply(toConfigMap, desired) {
+		return syncResult{resourceVersion: toConfigMap.ResourceVersion}, nil
+	}
+	err = r.upgradeManagedFields(ctx, toConfigMap)
 	if err != nil {
-		klog.Error("clean clusterConfig finalizer err: ", err)
+		klog.Errorf("[toConfigMap] in [%v] namespace Failed to upgrade managed fields error: %v\n", namespace, err)
+		return syncResult{}, err
+	}
+	err = r.applyCopy(ctx, desired)
+	if err != nil {
+		klog.Errorf("[toConfigMap] in [%v] namespace Failed to update error: %v\n", namespace, err)
+		return syncResult{}, err
+	}
+	klog.Infof("[toConfigMap] Updated with clusterConfig.Spec.Data in [%v] namespace\n", namespace)
+
+	return syncResult{resourceVersion: desired.ResourceVersion, reason: reason}, nil
+}
+
+// handleSecrets 处理 secrets 资源对象
+// 单个 namespace 失败不影响其他 namespace，所有错误在最后一并返回
+func (r *ClusterConfigController) handleSecrets(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespaceList []string) error {
+	// 合并 binaryData data stringData
+	a := secretData(clusterConfig)
+
+	// 校验 secret type 所需的 key，不满足时所有 namespace 都无法创建，直接返回
+	err := validateSecretData(secretType(clusterConfig), a)
+	if err != nil {
+		for _, namespace := range namespaceList {
+			setNamespaceStatus(clusterConfig, namespace, "", err)
+		}
 		return err
 	}
 
-	return nil
+	klog.Infof("namespace list: %v\n", namespaceList)
+	hash := newSecret(clusterConfig, "", a).Annotations[clusterconfigv1alpha1.AnnotationContentHash]
+
+	copies := &v1.SecretList{}
+	return r.syncNamespaces(ctx, clusterConfig, namespaceList, hash, copies, func(namespace string) (syncResult, error) {
+		return r.syncSecret(ctx, clusterConfig, namespace, a, hash)
+	})
 }
 
-// FIXME: 可以抽象出来，冗于代码太多了
-func (r *ClusterConfigController) handleNamespaceIsAllForConfigmaps(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig) error {
-	klog.Infof("create cluster config for all namespace...")
-	clusterNamespaceList := v1.NamespaceList{}
-	err := r.client.List(ctx, &clusterNamespaceList)
+// syncNamespaces 使用 NamespaceWorkers 个 worker 并发同步各 namespace，
+// 同步过程中只读取 clusterConfig，全部完成后再按顺序记录各 namespace 的 status。
+// 资源对象的 hash annotation 与 resourceVersion 都与上一次同步成功时一致的 namespace 直接跳过，
+// 只需要一次 cache list，不需要逐个获取与比较资源对象的内容
+func (r *ClusterConfigController) syncNamespaces(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespaceList []string, hash string, copies client.ObjectList, sync func(namespace string) (syncResult, error)) error {
+	versions, err := r.listCopyVersions(ctx, clusterConfig, copies)
 	if err != nil {
 		return err
 	}
 
-	for _, namespace := range clusterNamespaceList.Items {
-		klog.Infof("namespace to create configmaps: %v\n", namespace)
-		toConfigMap := &v1.ConfigMap{}
-		err := r.client.Get(ctx, client.ObjectKey{Name: clusterConfig.Name, Namespace: namespace.Name}, toConfigMap)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				toConfigMap = newConfigMap(clusterConfig, namespace.Name)
-
-				err = r.client.Create(ctx, toConfigMap, &client.CreateOptions{})
-				if err != nil {
-					klog.Errorf("[toConfigMap] in [%v] namespace Failed to create error: %v\n", namespace, err)
-					return err
-				}
-				klog.Infof("[toConfigMap] Created in [%v] namespace\n", namespace)
-			} else {
-				klog.Errorf("[toConfigMap] Failed to get in [%v] namespace, error: %v", namespace, err)
-				return err
-			}
+	errs := make([]error, len(namespaceList))
+	results := make([]syncResult, len(namespaceList))
+	processed := make([]bool, len(namespaceList))
+	workqueue.ParallelizeUntil(ctx, r.namespaceWorkers(), len(namespaceList), func(i int) {
+		namespace := namespaceList[i]
+		if isUpToDate(clusterConfig, namespace, hash, versions[namespace]) {
+			results[i].resourceVersion = versions[namespace].resourceVersion
+		} else {
+			start := time.Now()
+			results[i], errs[i] = sync(namespace)
+			metrics.NamespaceSyncDuration.WithLabelValues(clusterConfig.Namespace, clusterConfig.Name, namespace).Observe(time.Since(start).Seconds())
 		}
-
-		// Update toSecret data if data is changed.
-		if !reflect.DeepEqual(toConfigMap.Data, clusterConfig.Spec.Data) {
-			toConfigMap.Data = clusterConfig.Spec.Data
-			err = r.client.Update(ctx, toConfigMap, &client.UpdateOptions{})
-			if err != nil {
-				klog.Errorf("[toConfigMap] in [%v] namespace Failed to update error: %v\n", namespace, err)
-				return err
+		processed[i] = true
+	})
+
+	failed := make([]error, 0)
+	events := make(namespaceEvents)
+	for i, namespace := range namespaceList {
+		// ctx 取消后未处理的 namespace 保持原状态，下次调协重试
+		if !processed[i] {
+			failed = append(failed, fmt.Errorf("namespace %s: %w", namespace, ctx.Err()))
+			continue
+		}
+		err := errs[i]
+		switch {
+		case err == nil:
+			if results[i].reason != "" {
+				events.add(results[i].reason, namespace)
+			}
+			if results[i].reason == EventReasonDriftReverted {
+				metrics.DriftCorrected.WithLabelValues(clusterConfig.Namespace, clusterConfig.Name, namespace).Inc()
 			}
-			klog.Infof("[toConfigMap] Updated with clusterConfig.Spec.Data in [%v] namespace\n", namespace)
+		// 漂移只记录在 status 中，不视为调协失败
+		case stderrors.Is(err, errDrifted):
+			events.add(EventReasonDriftDetected, namespace)
+		case stderrors.Is(err, errConflict):
+			events.add(EventReasonConflictSkipped, namespace)
+			fallthrough
+		default:
+			failed = append(failed, fmt.Errorf("namespace %s: %w", namespace, err))
+			metrics.SyncFailures.WithLabelValues(clusterConfig.Namespace, clusterConfig.Name, namespace).Inc()
+		}
+		setNamespaceStatus(clusterConfig, namespace, hash, err)
+		if err == nil {
+			findNamespaceStatus(clusterConfig, namespace).ResourceVersion = results[i].resourceVersion
 		}
-
 	}
+	r.recordNamespaceEvents(clusterConfig, targetKind(clusterConfig), targetName(clusterConfig), events)
 
-	return nil
+	return utilerrors.NewAggregate(failed)
 }
 
-// handleConfigmaps 处理 configmaps 资源对象
-func (r *ClusterConfigController) handleConfigmaps(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig) error {
+// syncResult 同步单个 namespace 的结果
+type syncResult struct {
+	// resourceVersion 同步后资源对象的 resourceVersion
+	resourceVersion string
+	// reason 对资源对象做的修改，未修改时为空
+	reason EventReason
+}
 
-	// 1. 先分割出目标 namespace
-	namespaceList := splitString(clusterConfig.Spec.NamespaceList, ",")
-	klog.Infof("namespace list: %v\n", namespaceList)
+// copyVersion 资源对象上记录的 hash 与 resourceVersion
+type copyVersion struct {
+	hash            string
+	resourceVersion string
+}
 
-	// 处理 namespace 字段为 "all"时的逻辑
-	if len(namespaceList) == 1 && namespaceList[0] == "all" {
-		return r.handleNamespaceIsAllForConfigmaps(ctx, clusterConfig)
+// listCopyVersions 从 cache 中列出该 ClusterConfig 管理的资源对象，返回 namespace -> copyVersion，
+// 只读取 metadata，不做 deep copy
+func (r *ClusterConfigController) listCopyVersions(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig, list client.ObjectList) (map[string]copyVersion, error) {
+	err := r.client.List(ctx, list, client.MatchingLabels{clusterconfigv1alpha1.LabelClusterConfigUID: string(clusterConfig.UID)}, client.UnsafeDisableDeepCopy)
+	if err != nil {
+		return nil, err
 	}
-
-	// 2. 遍历 namespace
-	// 先去各个 namespace 查找是否存在，
-	// 如果不存在，则创建，
-	// 如果已经存在，则比较 data 字段是否一致，如果不一致则修改
-	// FIXME: 注意这里会有一种情况：就是修改 namespaceList 结果该删除的未删除的情况
-	for _, namespace := range namespaceList {
-		klog.Infof("namespace to create configmaps: %v\n", namespace)
-		toConfigMap := &v1.ConfigMap{}
-		err := r.client.Get(ctx, client.ObjectKey{Name: clusterConfig.Name, Namespace: namespace}, toConfigMap)
+	versions := make(map[string]copyVersion)
+	err = meta.EachListItem(list, func(o runtime.Object) error {
+		obj, err := meta.Accessor(o)
 		if err != nil {
-			if errors.IsNotFound(err) {
-				toConfigMap = newConfigMap(clusterConfig, namespace)
-
-				err = r.client.Create(ctx, toConfigMap, &client.CreateOptions{})
-				if err != nil {
-					klog.Errorf("[toConfigMap] in [%v] namespace Failed to create error: %v\n", namespace, err)
-					return err
-				}
-				klog.Infof("[toConfigMap] Created in [%v] namespace\n", namespace)
-			} else {
-				klog.Errorf("[toConfigMap] Failed to get in [%v] namespace, error: %v", namespace, err)
-				return err
-			}
+			return err
 		}
-
-		// Update toSecret data if data is changed.
-		if !reflect.DeepEqual(toConfigMap.Data, clusterConfig.Spec.Data) {
-			toConfigMap.Data = clusterConfig.Spec.Data
-			err = r.client.Update(ctx, toConfigMap, &client.UpdateOptions{})
-			if err != nil {
-				klog.Errorf("[toConfigMap] in [%v] namespace Failed to update error: %v\n", namespace, err)
-				return err
-			}
-			klog.Infof("[toConfigMap] Updated with clusterConfig.Spec.Data in [%v] namespace\n", namespace)
+		if obj.GetName() == targetName(clusterConfig) {
+			versions[obj.GetNamespace()] = copyVersion{hash: obj.GetAnnotations()[clusterconfigv1alpha1.AnnotationContentHash], resourceVersion: obj.GetResourceVersion()}
 		}
+		return nil
+	})
+	return versions, err
+}
 
+// isUpToDate 资源对象在上一次同步成功后没有被修改，且期望内容没有变化
+func isUpToDate(clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespace, hash string, version copyVersion) bool {
+	status := findNamespaceStatus(clusterConfig, namespace)
+	if status == nil || status.Phase != clusterconfigv1alpha1.NamespacePhaseSynced || status.ResourceVersion == "" {
+		return false
 	}
-
-	return nil
+	return status.ContentHash == hash && version.hash == hash && version.resourceVersion == status.ResourceVersion
 }
 
-// FIXME: 可以抽象出来，冗于代码太多了
-func (r *ClusterConfigController) handleNamespaceIsAllForSecrets(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig, a map[string][]byte) error {
-	klog.Infof("create cluster config for all namespace...")
-	clusterNamespaceList := v1.NamespaceList{}
-	err := r.client.List(ctx, &clusterNamespaceList)
-	if err != nil {
-		return err
+func (r *ClusterConfigController) namespaceWorkers() int {
+	if r.NamespaceWorkers <= 0 {
+		return DefaultNamespaceWorkers
 	}
+	return r.NamespaceWorkers
+}
 
-	for _, namespace := range clusterNamespaceList.Items {
-		klog.Infof("namespace to create secret: %v\n", namespace)
-		toSecret := &v1.Secret{}
-		err := r.client.Get(ctx, client.ObjectKey{Name: clusterConfig.Name, Namespace: namespace.Name}, toSecret)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				toSecret = newSecret(clusterConfig, namespace.Namespace, a)
-
-				// FIXME: cross-namespace owner references are disallowed, owner's namespace default, obj's namespace test[toSecret] Failed to set controller reference
-				//err := controllerutil.SetControllerReference(clusterConfig, toSecret, r.Scheme)
-				//if err != nil {
-				//	klog.Error(err, "[toSecret] Failed to set controller reference")
-				//	return err
-				//}
-
-				err = r.client.Create(ctx, toSecret, &client.CreateOptions{})
-				if err != nil {
-					klog.Errorf("[toSecret] in [%v] namespace Failed to create error: %v\n", namespace, err)
-					return err
-				}
-				klog.Infof("[toSecret] Created in [%v] namespace\n", namespace)
-			} else {
-				klog.Errorf("[toSecret] Failed to get in [%v] namespace, error: %v", namespace, err)
-				return err
+// syncSecret 先去 namespace 查找是否存在，
+// 如果不存在，则创建，
+// 如果已经存在，则比较 data 字段是否一致，如果不一致则修改
+// 被手动修改或删除时按 driftPolicy 处理，写入统一使用 server-side apply
+func (r *ClusterConfigController) syncSecret(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespace string, a map[string][]byte, hash string) (syncResult, error) {
+	klog.Infof("namespace to create secret: %v\n", namespace)
+	desired := newSecret(clusterConfig, namespace, a)
+	toSecret := &v1.Secret{}
+	reason := EventReasonUpdated
+	err := r.getCopy(ctx, client.ObjectKey{Name: targetName(clusterConfig), Namespace: namespace}, toSecret)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Errorf("[toSecret] Failed to get in [%v] namespace, error: %v", namespace, err)
+			return syncResult{}, err
+		}
+		if isDrift(clusterConfig, namespace, hash) {
+			revert, err := r.handleDrift(clusterConfig, namespace, "was deleted")
+			if !revert {
+				return syncResult{}, err
 			}
+			reason = EventReasonDriftReverted
+		} else {
+			reason = EventReasonCreated
 		}
+		// 不允许跨 namespace 的 owner references，所属 ClusterConfig 通过 label 记录
+		err = r.applyCopy(ctx, desired)
+		if err != nil {
+			klog.Errorf("[toSecret] in [%v] namespace Failed to create error: %v\n", namespace, err)
+			return syncResult{}, err
+		}
+		klog.Infof("[toSecret] Created in [%v] namespace\n", namespace)
+		return syncResult{resourceVersion: desired.ResourceVersion, reason: reason}, nil
+	}
 
-		// Update toSecret data if data is changed.
-		if !reflect.DeepEqual(toSecret.Data, a) {
-			toSecret.Data = a
-			err = r.client.Update(ctx, toSecret, &client.UpdateOptions{})
-			if err != nil {
-				klog.Errorf("[toSecret] in [%v] namespace Failed to update error: %v\n", namespace, err)
-				return err
-			}
-			klog.Infof("[toSecret] Updated with clusterConfig.Spec.Data in [%v] namespace\n", namespace)
+	// 已存在的资源对象需要确认是否可以接管
+	contentMatches := toSecret.Type == desired.Type && equalBytesMap(toSecret.Data, desired.Data)
+	err = r.checkAdoption(clusterConfig, toSecret, contentMatches)
+	if err != nil {
+		klog.Errorf("[toSecret] in [%v] namespace can not be adopted: %v\n", namespace, err)
+		return syncResult{}, err
+	}
+	if !contentMatches && isManagedBy(toSecret, clusterConfig) && isDrift(clusterConfig, namespace, hash) {
+		revert, err := r.handleDrift(clusterConfig, namespace, "was modified")
+		if !revert {
+			return syncResult{resourceVersion: toSecret.ResourceVersion}, err
+		}
+		reason = EventReasonDriftReverted
+	}
 
+	// secret type 不可修改，type 变更时先删除再重建
+	if toSecret.Type != desired.Type {
+		klog.Infof("[toSecret] type changed from %v to %v in [%v] namespace, recreate it\n", toSecret.Type, desired.Type, namespace)
+		err = r.client.Delete(ctx, toSecret, client.Preconditions{UID: &toSecret.UID})
+		if err != nil && !errors.IsNotFound(err) {
+			klog.Errorf("[toSecret] in [%v] namespace Failed to delete error: %v\n", namespace, err)
+			return syncResult{}, err
+		}
+		err = r.applyCopy(ctx, desired)
+		if err != nil {
+			klog.Errorf("[toSecret] in [%v] namespace Failed to create error: %v\n", namespace, err)
+			return syncResult{}, err
 		}
+		klog.Infof("[toSecret] Recreated in [%v] namespace\n", namespace)
+		return syncResult{resourceVersion: desired.ResourceVersion, reason: reason}, nil
+	}
 
+	// Apply toSecret if data or metadata is changed.
+	if contentMatches && !needsApply(toSecret, desired) {
+		return syncResult{resourceVersion: toSecret.ResourceVersion}, nil
+	}
+	err = r.upgradeManagedFields(ctx, toSecret)
+	if err != nil {
+		klog.Errorf("[toSecret] in [%v] namespace Failed to upgrade managed fields error: %v\n", namespace, err)
+		return syncResult{}, err
+	}
+	err = r.applyCopy(ctx, desired)
+	if err != nil {
+		klog.Errorf("[toSecret] in [%v] namespace Failed to update error: %v\n", namespace, err)
+		return syncResult{}, err
 	}
+	klog.Infof("[toSecret] Updated with clusterConfig.Spec.Data in [%v] namespace\n", namespace)
 
-	return nil
+	return syncResult{resourceVersion: desired.ResourceVersion, reason: reason}, nil
 }
 
-// handleSecrets 处理 secrets 资源对象
-func (r *ClusterConfigController) handleSecrets(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig) error {
-	// 處理 string -> []byte
-	a := make(map[string][]byte, 0)
+// getCopy 获取目标 namespace 中的资源对象，cache 中只有带管理 label 的资源对象，
+// cache 中不存在时再从 apiserver 确认，避免未管理的同名资源对象被当作不存在而直接覆盖
+func (r *ClusterConfigController) getCopy(ctx context.Context, key client.ObjectKey, obj client.Object) error {
+	err := r.client.Get(ctx, key, obj)
+	if !errors.IsNotFound(err) {
+		return err
+	}
+	return r.apiReader.Get(ctx, key, obj)
+}
 
-	for i, k := range clusterConfig.Spec.Data {
-		a[i] = []byte(k)
+// applyCopy 以 server-side apply 写入资源对象，obj 只包含本 ClusterConfig 管理的字段：
+// 其他工具在资源对象上添加的 labels annotations 等字段不受影响，
+// 之前写入、现在不再声明的字段（例如从模版中移除的 label）会被 apiserver 删除。
+// 与其他字段管理者冲突时强制获取所有权，以 ClusterConfig 为准
+func (r *ClusterConfigController) applyCopy(ctx context.Context, obj client.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+	if err != nil {
+		return err
+	}
+	// apply 请求需要携带 apiVersion kind，且不能携带 resourceVersion managedFields
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	obj.SetResourceVersion("")
+	obj.SetManagedFields(nil)
+	// client 把响应解码到请求对象中，已有的 map 会与响应合并，混入其他工具在资源对象上添加的 key；
+	// 解码到副本中，obj 保持只包含期望的字段，只取回 resourceVersion
+	applied := obj.DeepCopyObject().(client.Object)
+	err = r.client.Patch(ctx, applied, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership)
+	if err != nil {
+		return err
 	}
+	obj.SetResourceVersion(applied.GetResourceVersion())
+	labels := obj.GetLabels()
+	metrics.PropagatedBytes.WithLabelValues(labels[clusterconfigv1alpha1.LabelClusterConfigNamespace], labels[clusterconfigv1alpha1.LabelClusterConfigName]).Add(float64(dataSize(obj)))
+	return nil
+}
 
-	// 1. 先分割出目标 namespace
-	namespaceList := splitString(clusterConfig.Spec.NamespaceList, ",")
-	klog.Infof("namespace list: %v\n", namespaceList)
+// legacyFieldManagers 旧版本使用 Create Update 写入资源对象，没有指定 field manager，
+// apiserver 以 User-Agent 中的程序名作为 field manager：镜像中为 myclusterconfigoperator，本地运行时为当前程序名
+var legacyFieldManagers = sets.New[string]("myclusterconfigoperator", filepath.Base(os.Args[0]))
 
-	// 处理 namespace 字段为 "all"时的逻辑
-	if len(namespaceList) == 1 && namespaceList[0] == "all" {
-		return r.handleNamespaceIsAllForSecrets(ctx, clusterConfig, a)
+// upgradeManagedFields 把旧版本 field manager 拥有的字段转移给 FieldManager。
+// apply 只会删除 FieldManager 自己拥有、本次没有声明的字段，不转移时旧版本写入的 key label
+// 在从 spec 或模版中移除后会一直保留在资源对象上。已转移或没有旧版本 field manager 时不发送请求
+func (r *ClusterConfigController) upgradeManagedFields(ctx context.Context, obj client.Object) error {
+	patch, err := csaupgrade.UpgradeManagedFieldsPatch(obj, legacyFieldManagers, FieldManager)
+	if err != nil || patch == nil {
+		return err
 	}
+	return r.client.Patch(ctx, obj, client.RawPatch(types.JSONPatchType, patch))
+}
 
-	// 2. 遍历 namespace
-	// 先去各个 namespace 查找是否存在，
-	// 如果不存在，则创建，
-	// 如果已经存在，则比较 data 字段是否一致，如果不一致则修改
-	for _, namespace := range namespaceList {
-		klog.Infof("namespace to create secret: %v\n", namespace)
-		toSecret := &v1.Secret{}
-		err := r.client.Get(ctx, client.ObjectKey{Name: clusterConfig.Name, Namespace: namespace}, toSecret)
-		if err != nil {
-			if errors.IsNotFound(err) {
-				toSecret = newSecret(clusterConfig, namespace, a)
-
-				// FIXME: cross-namespace owner references are disallowed, owner's namespace default, obj's namespace test[toSecret] Failed to set controller reference
-				//err := controllerutil.SetControllerReference(clusterConfig, toSecret, r.Scheme)
-				//if err != nil {
-				//	klog.Error(err, "[toSecret] Failed to set controller reference")
-				//	return err
-				//}
-
-				err = r.client.Create(ctx, toSecret, &client.CreateOptions{})
-				if err != nil {
-					klog.Errorf("[toSecret] in [%v] namespace Failed to create error: %v\n", namespace, err)
-					return err
-				}
-				klog.Infof("[toSecret] Created in [%v] namespace\n", namespace)
-			} else {
-				klog.Errorf("[toSecret] Failed to get in [%v] namespace, error: %v", namespace, err)
-				return err
-			}
+// dataSize 资源对象 data 中 key 与 value 的长度之和
+func dataSize(obj client.Object) int {
+	size := 0
+	switch o := obj.(type) {
+	case *v1.ConfigMap:
+		for k, v := range o.Data {
+			size += len(k) + len(v)
 		}
-
-		// FIXME 报错
-		// 6. Check if `toSecret` is managed by secret-mirror-controller.
-		//if !metav1.IsControlledBy(toSecret, clusterConfig) {
-		//	klog.Error(err, "[toSecret] Not controlled by SecretMirror")
-		//	return err
-		//}
-
-		// Update toSecret data if data is changed.
-		if !reflect.DeepEqual(toSecret.Data, a) {
-			toSecret.Data = a
-			err = r.client.Update(ctx, toSecret, &client.UpdateOptions{})
-			if err != nil {
-				klog.Errorf("[toSecret] in [%v] namespace Failed to update error: %v\n", namespace, err)
-				return err
-			}
-			klog.Infof("[toSecret] Updated with clusterConfig.Spec.Data in [%v] namespace\n", namespace)
-
+		for k, v := range o.BinaryData {
+			size += len(k) + len(v)
+		}
+	case *v1.Secret:
+		for k, v := range o.Data {
+			size += len(k) + len(v)
+		}
+		for k, v := range o.StringData {
+			size += len(k) + len(v)
 		}
-
 	}
+	return size
+}
 
-	return nil
+// needsApply 判断已存在的资源对象是否需要重新 apply：
+// hash annotation 与期望不一致（spec 或模版有变更，需要移除不再声明的字段），或者期望的 labels annotations 缺失
+func needsApply(live, desired metav1.Object) bool {
+	if live.GetAnnotations()[clusterconfigv1alpha1.AnnotationContentHash] != desired.GetAnnotations()[clusterconfigv1alpha1.AnnotationContentHash] {
+		return true
+	}
+	return !containsStringMap(live.GetLabels(), desired.GetLabels()) || !containsStringMap(live.GetAnnotations(), desired.GetAnnotations())
 }
 
+// newSecret secretData 在各 namespace 的 worker 之间共享，资源对象使用自己的副本
 func newSecret(clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespace string, secretData map[string][]byte) *v1.Secret {
+	data := make(map[string][]byte, len(secretData))
+	for k, v := range secretData {
+		data[k] = v
+	}
 	toSecret := &v1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      clusterConfig.Name,
+			Name:      targetName(clusterConfig),
 			Namespace: namespace,
 		},
-		Data: secretData,
+		Data: data,
+		Type: secretType(clusterConfig),
 	}
+	applyTemplateMetadata(clusterConfig, toSecret)
+	applyOwnerLabels(clusterConfig, toSecret)
+	setContentHash(toSecret, map[string]interface{}{"type": toSecret.Type, "data": toSecret.Data})
 	return toSecret
 }
 
+// secretType 未设置时与 apiserver 一样默认为 Opaque
+func secretType(clusterConfig *clusterconfigv1alpha1.ClusterConfig) v1.SecretType {
+	if clusterConfig.Spec.Type == "" {
+		return v1.SecretTypeOpaque
+	}
+	return clusterConfig.Spec.Type
+}
+
+// validateSecretData 校验内置 secret type 必须包含的 key，与 apiserver 的校验规则一致
+func validateSecretData(secretType v1.SecretType, data map[string][]byte) error {
+	var required []string
+	switch secretType {
+	case v1.SecretTypeTLS:
+		required = []string{v1.TLSCertKey, v1.TLSPrivateKeyKey}
+	case v1.SecretTypeDockerConfigJson:
+		required = []string{v1.DockerConfigJsonKey}
+	case v1.SecretTypeDockercfg:
+		required = []string{v1.DockerConfigKey}
+	case v1.SecretTypeSSHAuth:
+		required = []string{v1.SSHAuthPrivateKey}
+	case v1.SecretTypeBasicAuth:
+		// username 与 password 至少需要一个
+		_, hasUsername := data[v1.BasicAuthUsernameKey]
+		_, hasPassword := data[v1.BasicAuthPasswordKey]
+		if !hasUsername && !hasPassword {
+			return fmt.Errorf("secret type %s requires key %s or %s", secretType, v1.BasicAuthUsernameKey, v1.BasicAuthPasswordKey)
+		}
+	}
+
+	for _, key := range required {
+		if _, ok := data[key]; !ok {
+			return fmt.Errorf("secret type %s requires key %s", secretType, key)
+		}
+	}
+	return nil
+}
+
+// validateConfigMapData data 与 binaryData 中不能有相同的 key，与 apiserver 的校验规则一致
+func validateConfigMapData(clusterConfig *clusterconfigv1alpha1.ClusterConfig) error {
+	duplicated := make([]string, 0)
+	for key := range clusterConfig.Spec.BinaryData {
+		if _, ok := clusterConfig.Spec.Data[key]; ok {
+			duplicated = append(duplicated, key)
+		}
+	}
+	if len(duplicated) != 0 {
+		sort.Strings(duplicated)
+		return fmt.Errorf("configmap keys %s are set in both data and binaryData", strings.Join(duplicated, ", "))
+	}
+	return nil
+}
+
 func newConfigMap(clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespace string) *v1.ConfigMap {
 	toSecret := &v1.ConfigMap{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      clusterConfig.Name,
+			Name:      targetName(clusterConfig),
 			Namespace: namespace,
 		},
-		Data: clusterConfig.Spec.Data,
 	}
+	toSecret.Data, toSecret.BinaryData = configMapData(clusterConfig)
+	applyTemplateMetadata(clusterConfig, toSecret)
+	applyOwnerLabels(clusterConfig, toSecret)
+	setContentHash(toSecret, map[string]interface{}{"data": toSecret.Data, "binaryData": toSecret.BinaryData})
 	return toSecret
 }
 
-func splitString(input, separator string) []string {
-	// 去除空格
-	input = strings.ReplaceAll(input, " ", "")
-	// 使用 strings.Split 进行分割
-	result := strings.Split(input, separator)
-	// 排序
-	sort.StringSlice(result).Sort()
-	return result
+// setContentHash 计算期望内容与 labels annotations 的 hash，记录到资源对象的 annotation 上
+func setContentHash(obj metav1.Object, content map[string]interface{}) {
+	content["labels"] = obj.GetLabels()
+	content["annotations"] = obj.GetAnnotations()
+	obj.SetAnnotations(mergeStringMap(obj.GetAnnotations(), map[string]string{clusterconfigv1alpha1.AnnotationContentHash: contentHash(content)}))
 }
 
-// 查找是否含有 Finalizer 字段
-func containsFinalizer(clusterconfig *clusterconfigv1alpha1.ClusterConfig, namespaceList []string) []string {
-	needToAddFinalizer := make([]string, 0)
+// applyTemplateMetadata 把模版中的 labels annotations 合并到资源对象上
+func applyTemplateMetadata(clusterConfig *clusterconfigv1alpha1.ClusterConfig, obj metav1.Object) {
+	if clusterConfig.Spec.Template == nil {
+		return
+	}
+	obj.SetLabels(mergeStringMap(obj.GetLabels(), clusterConfig.Spec.Template.Metadata.Labels))
+	obj.SetAnnotations(mergeStringMap(obj.GetAnnotations(), clusterConfig.Spec.Template.Metadata.Annotations))
+}
 
-	for _, namespace := range namespaceList {
-		found := false
+// mergeStringMap 把 src 合并到 dst，返回合并后的 map
+func mergeStringMap(dst, src map[string]string) map[string]string {
+	for k, v := range src {
+		if dst == nil {
+			dst = make(map[string]string, len(src))
+		}
+		dst[k] = v
+	}
+	return dst
+}
 
-		for _, finalizer := range clusterconfig.Finalizers {
-			if finalizer == namespace {
-				found = true
-				break
-			}
+// containsStringMap 判断 sub 中的 key value 是否都包含在 m 中
+func containsStringMap(m, sub map[string]string) bool {
+	for k, v := range sub {
+		if old, ok := m[k]; !ok || old != v {
+			return false
 		}
+	}
+	return true
+}
 
-		if !found {
-			needToAddFinalizer = append(needToAddFinalizer, namespace)
+// configMapData ConfigMap 期望的 data 与 binaryData，为空时返回 nil，与 apiserver 返回的结果保持一致。
+// 返回副本，各 namespace 的资源对象不与 spec 共享 map
+func configMapData(clusterConfig *clusterconfigv1alpha1.ClusterConfig) (map[string]string, map[string][]byte) {
+	var data map[string]string
+	if len(clusterConfig.Spec.Data) != 0 {
+		data = make(map[string]string, len(clusterConfig.Spec.Data))
+		for k, v := range clusterConfig.Spec.Data {
+			data[k] = v
 		}
 	}
+	var binaryData map[string][]byte
+	if len(clusterConfig.Spec.BinaryData) != 0 {
+		binaryData = make(map[string][]byte, len(clusterConfig.Spec.BinaryData))
+		for k, v := range clusterConfig.Spec.BinaryData {
+			binaryData[k] = v
+		}
+	}
+	return data, binaryData
+}
 
-	return needToAddFinalizer
+// secretData Secret 期望的 data，与原生 Secret 一致：先取 binaryData 与 data，stringData 中的同名 key 最后覆盖
+func secretData(clusterConfig *clusterconfigv1alpha1.ClusterConfig) map[string][]byte {
+	a := make(map[string][]byte, len(clusterConfig.Spec.BinaryData)+len(clusterConfig.Spec.Data)+len(clusterConfig.Spec.StringData))
+	for k, v := range clusterConfig.Spec.BinaryData {
+		a[k] = v
+	}
+	// 處理 string -> []byte
+	for k, v := range clusterConfig.Spec.Data {
+		a[k] = []byte(v)
+	}
+	for k, v := range clusterConfig.Spec.StringData {
+		a[k] = []byte(v)
+	}
+	return a
 }
 
-func calculateNeedToDeleteNamespace(namespaceList, processedNamespace []string) []string {
-	// 创建一个映射用于存储列表 A 的元素
-	existenceMap := make(map[string]bool)
-	for _, item := range namespaceList {
-		existenceMap[item] = true
+// equalStringMap 比较两个 map，nil 与空 map 视为相等
+func equalStringMap(a, b map[string]string) bool {
+	if len(a) == 0 && len(b) == 0 {
+		return true
 	}
+	return reflect.DeepEqual(a, b)
+}
 
-	// 获取列表 B 中存在但列表 A 中不存在的元素
-	var needToDeleteNamespaces []string
-	for _, item := range processedNamespace {
-		if !existenceMap[item] {
-			needToDeleteNamespaces = append(needToDeleteNamespaces, item)
-			existenceMap[item] = true // 添加到映射中，以防止重复添加相同元素
+// equalBytesMap 比较两个 map，nil 与空 map 视为相等
+func equalBytesMap(a, b map[string][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		w, ok := b[k]
+		if !ok || !bytes.Equal(v, w) {
+			return false
 		}
 	}
-
-	return needToDeleteNamespaces
+	return true
 }
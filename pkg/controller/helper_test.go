@@ -0,0 +1,646 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	"github.com/myoperator/clusterconfigoperator/pkg/common"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"strings"
+	"testing"
+)
+
+// failingDeleteClient 删除指定 namespace 下的资源对象时返回错误
+type failingDeleteClient struct {
+	client.Client
+	namespace string
+}
+
+func (c *failingDeleteClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	if obj.GetNamespace() == c.namespace {
+		return fmt.Errorf("delete %s/%s: injected failure", obj.GetNamespace(), obj.GetName())
+	}
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+// fakeApplyClient controller-runtime 0.14 的 fake client 把 apply 当作 strategic merge patch 处理，
+// 不能创建资源对象，资源对象不存在时改为创建
+type fakeApplyClient struct {
+	client.Client
+}
+
+func (c *fakeApplyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() == types.ApplyPatchType {
+		existing := obj.DeepCopyObject().(client.Object)
+		err := c.Client.Get(ctx, client.ObjectKeyFromObject(obj), existing)
+		if errors.IsNotFound(err) {
+			return c.Client.Create(ctx, obj)
+		}
+	}
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// decodingApplyClient 与真实的 client 一样把 apply 的响应解码到请求对象中，请求对象中已有的 map 会与响应合并；
+// fake client 解码前会先清空请求对象
+type decodingApplyClient struct {
+	client.Client
+}
+
+func (c *decodingApplyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	result := obj.DeepCopyObject().(client.Object)
+	if err := c.Client.Patch(ctx, result, patch, opts...); err != nil {
+		return err
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, obj)
+}
+
+func newTestController(t testing.TB, objs ...client.Object) (*ClusterConfigController, client.Client) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := clusterconfigv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := &fakeApplyClient{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).Build()}
+	return NewClusterConfigController(c, c, klog.NewKlogr(), scheme, record.NewFakeRecorder(100)), c
+}
+
+func newTestClusterConfig(finalizers ...string) *clusterconfigv1alpha1.ClusterConfig {
+	return &clusterconfigv1alpha1.ClusterConfig{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test",
+			Namespace:  "default",
+			UID:        "test-uid",
+			Finalizers: finalizers,
+		},
+		Spec: clusterconfigv1alpha1.ClusterConfigSpec{
+			ConfigType: common.ConfigMaps,
+			Data:       map[string]string{"key": "value"},
+		},
+	}
+}
+
+func newTestCopy(clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespace string, managed bool) *v1.ConfigMap {
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: targetName(clusterConfig), Namespace: namespace},
+		Data:       map[string]string{"key": "value"},
+	}
+	if managed {
+		cm.Labels = ownerLabels(clusterConfig)
+	}
+	return cm
+}
+
+func assertNotFound(t *testing.T, c client.Client, namespace, name string) {
+	t.Helper()
+	err := c.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: name}, &v1.ConfigMap{})
+	if !errors.IsNotFound(err) {
+		t.Errorf("expected %s/%s to be deleted, got err %v", namespace, name, err)
+	}
+}
+
+func TestDeleteResourceRemovesEveryCopy(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	r, c := newTestController(t, cc,
+		newTestCopy(cc, "ns1", true),
+		newTestCopy(cc, "ns2", true),
+		newTestCopy(cc, "ns3", true),
+	)
+
+	if err := r.deleteResource(context.Background(), cc); err != nil {
+		t.Fatalf("deleteResource: %v", err)
+	}
+	for _, ns := range []string{"ns1", "ns2", "ns3"} {
+		assertNotFound(t, c, ns, "test")
+	}
+	if len(cc.Finalizers) != 0 {
+		t.Errorf("expected finalizers to be removed, got %v", cc.Finalizers)
+	}
+}
+
+// 旧版本以 namespace 命名的 Finalizer，其中某个 namespace 的资源对象已被手动删除，不能因 NotFound 中断
+func TestDeleteResourceLegacyFinalizersWithMissingCopy(t *testing.T) {
+	cc := newTestClusterConfig("ns1", "ns2", "ns3")
+	r, c := newTestController(t, cc,
+		newTestCopy(cc, "ns1", false),
+		newTestCopy(cc, "ns3", false),
+	)
+
+	if err := r.deleteResource(context.Background(), cc); err != nil {
+		t.Fatalf("deleteResource: %v", err)
+	}
+	assertNotFound(t, c, "ns1", "test")
+	assertNotFound(t, c, "ns3", "test")
+	if len(cc.Finalizers) != 0 {
+		t.Errorf("expected finalizers to be removed, got %v", cc.Finalizers)
+	}
+}
+
+// 旧版本遗留的同名资源对象已属于其他 ClusterConfig 时不删除
+func TestDeleteResourceLegacyKeepsOthersCopy(t *testing.T) {
+	cc := newTestClusterConfig("ns1")
+	other := newTestClusterConfig()
+	other.Name, other.UID = "other", "other-uid"
+	cm := newTestCopy(other, "ns1", true)
+	cm.Name = "test"
+	r, c := newTestController(t, cc, cm)
+
+	if err := r.deleteResource(context.Background(), cc); err != nil {
+		t.Fatalf("deleteResource: %v", err)
+	}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns1", Name: "test"}, &v1.ConfigMap{}); err != nil {
+		t.Errorf("expected copy of other clusterconfig to be kept, got err %v", err)
+	}
+}
+
+// 某个 namespace 删除失败时，其余 namespace 仍然处理，已处理完成的 namespace 的 Finalizer 移除，失败的保留
+func TestDeleteResourceContinuesPastFailure(t *testing.T) {
+	cc := newTestClusterConfig("ns1", "ns2", "ns3")
+	r, c := newTestController(t, cc,
+		newTestCopy(cc, "ns1", true),
+		newTestCopy(cc, "ns2", true),
+		newTestCopy(cc, "ns3", true),
+	)
+	r.client = &failingDeleteClient{Client: c, namespace: "ns2"}
+
+	err := r.deleteResource(context.Background(), cc)
+	if err == nil {
+		t.Fatal("expected error from failing namespace")
+	}
+	assertNotFound(t, c, "ns1", "test")
+	assertNotFound(t, c, "ns3", "test")
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns2", Name: "test"}, &v1.ConfigMap{}); err != nil {
+		t.Errorf("expected ns2 copy to remain, got err %v", err)
+	}
+
+	stored := &clusterconfigv1alpha1.ClusterConfig{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(cc), stored); err != nil {
+		t.Fatal(err)
+	}
+	if controllerutil.ContainsFinalizer(stored, "ns1") || controllerutil.ContainsFinalizer(stored, "ns3") {
+		t.Errorf("expected finalizers of finished namespaces to be removed, got %v", stored.Finalizers)
+	}
+	if !controllerutil.ContainsFinalizer(stored, "ns2") {
+		t.Errorf("expected finalizer of failed namespace to be kept, got %v", stored.Finalizers)
+	}
+
+	// 故障恢复后再次调协，剩余资源对象与 Finalizer 全部清理
+	r.client = c
+	if err := r.deleteResource(context.Background(), stored); err != nil {
+		t.Fatalf("deleteResource retry: %v", err)
+	}
+	assertNotFound(t, c, "ns2", "test")
+	if len(stored.Finalizers) != 0 {
+		t.Errorf("expected finalizers to be removed, got %v", stored.Finalizers)
+	}
+}
+
+// apply 只写入本 ClusterConfig 管理的字段，其他工具添加的 label annotation 保留。
+// fakeApplyClient 把 apply 当作 merge patch 处理，不校验字段所有权，移除字段的行为由 apiserver 保证
+func TestSyncConfigMapKeepsForeignMetadata(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	cm := newTestCopy(cc, "ns1", true)
+	cm.Labels["team"] = "payments"
+	cm.Annotations = map[string]string{"example.com/checksum": "abc"}
+	r, c := newTestController(t, cc, cm)
+
+	cc.Spec.Data["key"] = "changed"
+	if _, err := r.syncConfigMap(context.Background(), cc, "ns1", ""); err != nil {
+		t.Fatal(err)
+	}
+	got := &v1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns1", Name: "test"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Data["key"] != "changed" {
+		t.Errorf("expected data to be updated, got %q", got.Data["key"])
+	}
+	if got.Labels["team"] != "payments" || got.Annotations["example.com/checksum"] != "abc" {
+		t.Errorf("expected foreign metadata to be kept, got labels %v annotations %v", got.Labels, got.Annotations)
+	}
+}
+
+// 旧版本写入的资源对象在第一次 apply 前把字段所有权转移给 FieldManager，之后从 spec 中移除的 key 才会被删除
+func TestSyncConfigMapUpgradesLegacyFieldManager(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	cm := newTestCopy(cc, "ns1", true)
+	cm.ManagedFields = []metav1.ManagedFieldsEntry{{
+		Manager:    "myclusterconfigoperator",
+		Operation:  metav1.ManagedFieldsOperationUpdate,
+		APIVersion: "v1",
+		FieldsType: "FieldsV1",
+		FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:data":{".":{},"f:key":{}},"f:metadata":{"f:labels":{".":{},"f:api.practice.com/clusterconfig-uid":{}}}}`)},
+	}}
+	r, c := newTestController(t, cc, cm)
+
+	cc.Spec.Data["key"] = "changed"
+	if _, err := r.syncConfigMap(context.Background(), cc, "ns1", ""); err != nil {
+		t.Fatal(err)
+	}
+	got := &v1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns1", Name: "test"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if len(got.ManagedFields) != 1 {
+		t.Fatalf("expected a single managed fields entry, got %+v", got.ManagedFields)
+	}
+	entry := got.ManagedFields[0]
+	if entry.Manager != FieldManager || entry.Operation != metav1.ManagedFieldsOperationApply {
+		t.Errorf("expected fields to be owned by %s via Apply, got %s via %s", FieldManager, entry.Manager, entry.Operation)
+	}
+	if !strings.Contains(string(entry.FieldsV1.Raw), `"f:key"`) {
+		t.Errorf("expected data keys to be migrated, got %s", entry.FieldsV1.Raw)
+	}
+}
+
+// 其他工具在资源对象上添加的 key 随 apply 的响应返回，不能混入 spec，也不能下发到其他 namespace
+func TestSyncConfigMapDoesNotPropagateForeignKeys(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	cm := newTestCopy(cc, "ns1", true)
+	cm.Data["foreign"] = "kept"
+	r, c := newTestController(t, cc, cm)
+	r.client = &decodingApplyClient{Client: c}
+
+	cc.Spec.Data["key"] = "changed"
+	for _, namespace := range []string{"ns1", "ns2"} {
+		if _, err := r.syncConfigMap(context.Background(), cc, namespace, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := cc.Spec.Data["foreign"]; ok {
+		t.Errorf("expected spec data to be unchanged, got %v", cc.Spec.Data)
+	}
+	got := &v1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns2", Name: "test"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got.Data["foreign"]; ok || got.Data["key"] != "changed" {
+		t.Errorf("expected only desired data in ns2, got %v", got.Data)
+	}
+}
+
+// 多个 worker 并发更新已存在的资源对象，各自解码 apply 的响应，不能共享 spec 中的 map；
+// 需要 go test -race 运行才能发现并发写 map
+func TestSyncNamespacesUpdatesConcurrently(t *testing.T) {
+	for _, configType := range []string{common.ConfigMaps, common.Secrets} {
+		t.Run(configType, func(t *testing.T) {
+			cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+			cc.Spec.ConfigType = configType
+			objs := []client.Object{cc}
+			namespaceList := make([]string, 0)
+			for i := 0; i < 20; i++ {
+				namespace := fmt.Sprintf("ns-%d", i)
+				namespaceList = append(namespaceList, namespace)
+				meta := metav1.ObjectMeta{Name: "test", Namespace: namespace, Labels: ownerLabels(cc)}
+				if configType == common.Secrets {
+					objs = append(objs, &v1.Secret{ObjectMeta: meta, Type: v1.SecretTypeOpaque, Data: map[string][]byte{"key": []byte("value"), "foreign-" + namespace: []byte("kept")}})
+				} else {
+					objs = append(objs, &v1.ConfigMap{ObjectMeta: meta, Data: map[string]string{"key": "value", "foreign-" + namespace: "kept"}})
+				}
+			}
+			r, c := newTestController(t, objs...)
+			r.client = &decodingApplyClient{Client: c}
+			r.NamespaceWorkers = DefaultNamespaceWorkers
+			resetNamespaceStatus(cc, namespaceList)
+
+			cc.Spec.Data["key"] = "changed"
+			var err error
+			if configType == common.Secrets {
+				err = r.handleSecrets(context.Background(), cc, namespaceList)
+			} else {
+				err = r.handleConfigmaps(context.Background(), cc, namespaceList)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(cc.Spec.Data) != 1 {
+				t.Errorf("expected spec data to be unchanged, got %v", cc.Spec.Data)
+			}
+			for _, namespace := range namespaceList {
+				keys := make([]string, 0)
+				if configType == common.Secrets {
+					got := &v1.Secret{}
+					if err := c.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: "test"}, got); err != nil {
+						t.Fatal(err)
+					}
+					for key := range got.Data {
+						keys = append(keys, key)
+					}
+				} else {
+					got := &v1.ConfigMap{}
+					if err := c.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: "test"}, got); err != nil {
+						t.Fatal(err)
+					}
+					for key := range got.Data {
+						keys = append(keys, key)
+					}
+				}
+				if len(keys) != 2 {
+					t.Errorf("expected only key and foreign-%s in %s, got %v", namespace, namespace, keys)
+				}
+			}
+		})
+	}
+}
+
+// 删除 ClusterConfig 与 namespace 不再是目标时都按 deletionPolicy 处理资源对象：
+// Delete 删除，Orphan 只移除管理 label，Retain 原样保留
+func TestReleaseCopiesByDeletionPolicy(t *testing.T) {
+	for _, policy := range []clusterconfigv1alpha1.DeletionPolicy{
+		clusterconfigv1alpha1.DeletionPolicyDelete,
+		clusterconfigv1alpha1.DeletionPolicyOrphan,
+		clusterconfigv1alpha1.DeletionPolicyRetain,
+	} {
+		for _, deleting := range []bool{true, false} {
+			t.Run(fmt.Sprintf("%s/deleting=%v", policy, deleting), func(t *testing.T) {
+				cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+				cc.Spec.DeletionPolicy = policy
+				cm := newTestCopy(cc, "ns2", true)
+				cm.Labels["team"] = "payments"
+				r, c := newTestController(t, cc, cm)
+				before := &v1.ConfigMap{}
+				if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns2", Name: "test"}, before); err != nil {
+					t.Fatal(err)
+				}
+
+				if deleting {
+					if err := r.deleteResource(context.Background(), cc); err != nil {
+						t.Fatal(err)
+					}
+					if len(cc.Finalizers) != 0 {
+						t.Errorf("expected finalizers to be removed, got %v", cc.Finalizers)
+					}
+				} else {
+					cleaned, err := r.cleanupStaleCopies(context.Background(), cc, []string{"ns1"})
+					if err != nil {
+						t.Fatal(err)
+					}
+					wantCleaned := policy != clusterconfigv1alpha1.DeletionPolicyRetain
+					if got := containsString(cleaned, "ns2"); got != wantCleaned {
+						t.Errorf("expected ns2 cleaned %v, got %v", wantCleaned, cleaned)
+					}
+				}
+
+				if policy == clusterconfigv1alpha1.DeletionPolicyDelete {
+					assertNotFound(t, c, "ns2", "test")
+					return
+				}
+				got := &v1.ConfigMap{}
+				if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns2", Name: "test"}, got); err != nil {
+					t.Fatal(err)
+				}
+				if got.Data["key"] != "value" {
+					t.Errorf("expected data to be kept, got %v", got.Data)
+				}
+				if policy == clusterconfigv1alpha1.DeletionPolicyRetain {
+					if got.ResourceVersion != before.ResourceVersion || !reflect.DeepEqual(got.Labels, before.Labels) {
+						t.Errorf("expected copy to be left as it was, got labels %v resourceVersion %s", got.Labels, got.ResourceVersion)
+					}
+					return
+				}
+				if isManaged(got) || got.Labels[clusterconfigv1alpha1.LabelManagedBy] != "" || got.Labels["team"] != "payments" {
+					t.Errorf("expected only owner labels to be removed, got %v", got.Labels)
+				}
+			})
+		}
+	}
+}
+
+func TestValidateSecretData(t *testing.T) {
+	tests := []struct {
+		secretType v1.SecretType
+		keys       []string
+		wantErr    bool
+	}{
+		{secretType: v1.SecretTypeOpaque},
+		{secretType: v1.SecretTypeTLS, keys: []string{v1.TLSCertKey, v1.TLSPrivateKeyKey}},
+		{secretType: v1.SecretTypeTLS, keys: []string{v1.TLSCertKey}, wantErr: true},
+		{secretType: v1.SecretTypeTLS, keys: []string{v1.TLSPrivateKeyKey}, wantErr: true},
+		{secretType: v1.SecretTypeDockerConfigJson, keys: []string{v1.DockerConfigJsonKey}},
+		{secretType: v1.SecretTypeDockerConfigJson, keys: []string{v1.DockerConfigKey}, wantErr: true},
+		{secretType: v1.SecretTypeDockercfg, keys: []string{v1.DockerConfigKey}},
+		{secretType: v1.SecretTypeDockercfg, wantErr: true},
+		{secretType: v1.SecretTypeBasicAuth, keys: []string{v1.BasicAuthUsernameKey}},
+		{secretType: v1.SecretTypeBasicAuth, keys: []string{v1.BasicAuthPasswordKey}},
+		{secretType: v1.SecretTypeBasicAuth, keys: []string{"token"}, wantErr: true},
+		{secretType: v1.SecretTypeSSHAuth, keys: []string{v1.SSHAuthPrivateKey}},
+		{secretType: v1.SecretTypeSSHAuth, keys: []string{"id_rsa"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		data := make(map[string][]byte, len(tt.keys))
+		for _, key := range tt.keys {
+			data[key] = []byte("value")
+		}
+		if err := validateSecretData(tt.secretType, data); (err != nil) != tt.wantErr {
+			t.Errorf("%s %v: expected error %v, got %v", tt.secretType, tt.keys, tt.wantErr, err)
+		}
+	}
+}
+
+// secret type 不可修改，type 变更时删除后重新创建
+func TestSyncSecretRecreatesOnTypeChange(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	cc.Spec.ConfigType = common.Secrets
+	secret := &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "ns1", UID: "old-uid", Labels: ownerLabels(cc)},
+		Type:       v1.SecretTypeOpaque,
+		Data:       map[string][]byte{"key": []byte("value")},
+	}
+	r, c := newTestController(t, cc, secret)
+
+	cc.Spec.Type = v1.SecretTypeBasicAuth
+	cc.Spec.Data = map[string]string{v1.BasicAuthUsernameKey: "admin"}
+	data := secretData(cc)
+	hash := newSecret(cc, "", data).Annotations[clusterconfigv1alpha1.AnnotationContentHash]
+	if _, err := r.syncSecret(context.Background(), cc, "ns1", data, hash); err != nil {
+		t.Fatal(err)
+	}
+	got := &v1.Secret{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns1", Name: "test"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != v1.SecretTypeBasicAuth || got.UID == "old-uid" {
+		t.Errorf("expected secret to be recreated with type %s, got type %s uid %s", v1.SecretTypeBasicAuth, got.Type, got.UID)
+	}
+	if !reflect.DeepEqual(got.Data, map[string][]byte{v1.BasicAuthUsernameKey: []byte("admin")}) {
+		t.Errorf("expected only the new data, got %v", got.Data)
+	}
+}
+
+func TestCheckAdoption(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	other := newTestClusterConfig()
+	other.Name, other.UID = "other", "other-uid"
+	recreated := newTestClusterConfig()
+	recreated.UID = "old-uid"
+
+	tests := []struct {
+		name           string
+		policy         clusterconfigv1alpha1.AdoptionPolicy
+		labels         map[string]string
+		contentMatches bool
+		wantConflict   bool
+	}{
+		{name: "never unlabelled matching", policy: clusterconfigv1alpha1.AdoptionPolicyNever, contentMatches: true, wantConflict: true},
+		{name: "never unlabelled not matching", policy: clusterconfigv1alpha1.AdoptionPolicyNever, wantConflict: true},
+		{name: "if matching unlabelled matching", policy: clusterconfigv1alpha1.AdoptionPolicyIfMatching, contentMatches: true},
+		{name: "if matching unlabelled not matching", policy: clusterconfigv1alpha1.AdoptionPolicyIfMatching, wantConflict: true},
+		{name: "always unlabelled matching", policy: clusterconfigv1alpha1.AdoptionPolicyAlways, contentMatches: true},
+		{name: "always unlabelled not matching", policy: clusterconfigv1alpha1.AdoptionPolicyAlways},
+		{name: "never managed by self", policy: clusterconfigv1alpha1.AdoptionPolicyNever, labels: ownerLabels(cc)},
+		{name: "never managed by recreated self", policy: clusterconfigv1alpha1.AdoptionPolicyNever, labels: ownerLabels(recreated)},
+		{name: "always managed by other", policy: clusterconfigv1alpha1.AdoptionPolicyAlways, labels: ownerLabels(other), contentMatches: true, wantConflict: true},
+		{name: "if matching managed by other", policy: clusterconfigv1alpha1.AdoptionPolicyIfMatching, labels: ownerLabels(other), contentMatches: true, wantConflict: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, _ := newTestController(t)
+			clusterConfig := cc.DeepCopy()
+			clusterConfig.Spec.AdoptionPolicy = tt.policy
+			obj := &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "ns1", Labels: tt.labels}}
+			err := r.checkAdoption(clusterConfig, obj, tt.contentMatches)
+			if got := stderrors.Is(err, errConflict); got != tt.wantConflict || (err != nil && !got) {
+				t.Errorf("expected conflict %v, got %v", tt.wantConflict, err)
+			}
+		})
+	}
+}
+
+// data 与 binaryData 中有相同的 key 时 apiserver 会拒绝写入，所有 namespace 直接记为 Failed
+func TestHandleConfigmapsRejectsDuplicatedKeys(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	cc.Spec.BinaryData = map[string][]byte{"key": []byte("binary"), "other": []byte("binary")}
+	r, c := newTestController(t, cc)
+	resetNamespaceStatus(cc, []string{"ns1", "ns2"})
+
+	err := r.handleConfigmaps(context.Background(), cc, []string{"ns1", "ns2"})
+	if err == nil || !strings.Contains(err.Error(), "key") || strings.Contains(err.Error(), "other") {
+		t.Fatalf("expected error for duplicated key, got %v", err)
+	}
+	for _, namespace := range []string{"ns1", "ns2"} {
+		assertNotFound(t, c, namespace, "test")
+		if status := findNamespaceStatus(cc, namespace); status == nil || status.Phase != clusterconfigv1alpha1.NamespacePhaseFailed {
+			t.Errorf("expected %s to be Failed, got %+v", namespace, status)
+		}
+	}
+}
+
+// 与原生 Secret 一致：binaryData 优先级最低，data 覆盖 binaryData，stringData 最后覆盖
+func TestSecretDataPrecedence(t *testing.T) {
+	cc := newTestClusterConfig()
+	cc.Spec.BinaryData = map[string][]byte{"a": []byte("binary"), "b": []byte("binary"), "c": []byte("binary")}
+	cc.Spec.Data = map[string]string{"b": "data", "c": "data"}
+	cc.Spec.StringData = map[string]string{"c": "string"}
+
+	want := map[string][]byte{"a": []byte("binary"), "b": []byte("data"), "c": []byte("string")}
+	if got := secretData(cc); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestCleanupStaleCopiesContinuesPastFailure(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	r, c := newTestController(t, cc,
+		newTestCopy(cc, "ns1", true),
+		newTestCopy(cc, "ns2", true),
+		newTestCopy(cc, "ns3", true),
+		newTestCopy(cc, "keep", true),
+	)
+	r.client = &failingDeleteClient{Client: c, namespace: "ns1"}
+
+	cleaned, err := r.cleanupStaleCopies(context.Background(), cc, []string{"keep"})
+	if err == nil {
+		t.Fatal("expected error from failing namespace")
+	}
+	if fmt.Sprint(cleaned) != "[ns2 ns3]" {
+		t.Errorf("expected [ns2 ns3] to be cleaned, got %v", cleaned)
+	}
+	assertNotFound(t, c, "ns2", "test")
+	assertNotFound(t, c, "ns3", "test")
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "keep", Name: "test"}, &v1.ConfigMap{}); err != nil {
+		t.Errorf("expected target namespace copy to be kept, got err %v", err)
+	}
+}
+
+// countingClient 记录 Get 与 Patch 的次数，不是并发安全的
+type countingClient struct {
+	client.Client
+	gets, patches int
+}
+
+func (c *countingClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	c.gets++
+	return c.Client.Get(ctx, key, obj, opts...)
+}
+
+func (c *countingClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.patches++
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+// 同步成功后资源对象未变化时不再逐个获取，被修改后重新比较
+func TestHandleConfigmapsSkipsUpToDateCopies(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	r, c := newTestController(t, cc)
+	counting := &countingClient{Client: c}
+	r.client, r.apiReader = counting, counting
+	r.NamespaceWorkers = 1
+	namespaceList := []string{"ns1", "ns2", "ns3"}
+	resetNamespaceStatus(cc, namespaceList)
+
+	if err := r.handleConfigmaps(context.Background(), cc, namespaceList); err != nil {
+		t.Fatal(err)
+	}
+	got := &v1.ConfigMap{}
+	if err := c.Get(context.Background(), client.ObjectKey{Namespace: "ns1", Name: "test"}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Annotations[clusterconfigv1alpha1.AnnotationContentHash] == "" {
+		t.Error("expected content hash annotation on copy")
+	}
+
+	counting.gets, counting.patches = 0, 0
+	if err := r.handleConfigmaps(context.Background(), cc, namespaceList); err != nil {
+		t.Fatal(err)
+	}
+	if counting.gets != 0 || counting.patches != 0 {
+		t.Errorf("expected up-to-date copies to be skipped, got %d gets %d patches", counting.gets, counting.patches)
+	}
+
+	// 其他工具修改了资源对象，resourceVersion 变化后重新比较，内容一致时不写入
+	got.Labels["team"] = "payments"
+	if err := c.Update(context.Background(), got); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.handleConfigmaps(context.Background(), cc, namespaceList); err != nil {
+		t.Fatal(err)
+	}
+	if counting.gets != 1 || counting.patches != 0 {
+		t.Errorf("expected only the modified copy to be fetched, got %d gets %d patches", counting.gets, counting.patches)
+	}
+	if status := findNamespaceStatus(cc, "ns1"); status.ResourceVersion != got.ResourceVersion {
+		t.Errorf("expected resourceVersion %s in status, got %s", got.ResourceVersion, status.ResourceVersion)
+	}
+}
@@ -0,0 +1,347 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	"path"
+	"reflect"
+	"regexp"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sort"
+	"strings"
+)
+
+const (
+	// allNamespace NamespaceList 中的特殊字段，代表所有 namespace
+	allNamespace = "all"
+)
+
+// targetNamespaces 计算 ClusterConfig 需要下发的 namespace 列表，结果已排序去重
+// 先取 NamespaceList（具体名称、glob、正则）与 NamespaceSelector 匹配结果的并集，再去掉 ExcludeNamespaces 命中的部分，
+// 不存在或正在删除的 namespace 不会被选中，待其创建后由 namespace 事件重新入列
+func (r *ClusterConfigController) targetNamespaces(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig) ([]string, error) {
+	target, err := newNamespaceTarget(clusterConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	clusterNamespaceList := v1.NamespaceList{}
+	err = r.client.List(ctx, &clusterNamespaceList)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0)
+	for i := range clusterNamespaceList.Items {
+		namespace := &clusterNamespaceList.Items[i]
+		if namespace.DeletionTimestamp.IsZero() && target.matches(namespace.Name, namespace.Labels) {
+			names = append(names, namespace.Name)
+		}
+	}
+	return uniqueSorted(names), nil
+}
+
+// pruneDeletedNamespaces 把已删除或正在删除的 namespace 从 status 中移除
+func (r *ClusterConfigController) pruneDeletedNamespaces(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig) error {
+	recorded := make([]string, 0, len(clusterConfig.Status.ProcessedNamespace)+len(clusterConfig.Status.Namespaces))
+	recorded = append(recorded, clusterConfig.Status.ProcessedNamespace...)
+	for _, status := range clusterConfig.Status.Namespaces {
+		recorded = append(recorded, status.Namespace)
+	}
+
+	deleted := make([]string, 0)
+	for _, name := range uniqueSorted(recorded, allNamespace) {
+		namespace := &v1.Namespace{}
+		err := r.client.Get(ctx, client.ObjectKey{Name: name}, namespace)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				deleted = append(deleted, name)
+				continue
+			}
+			return err
+		}
+		if !namespace.DeletionTimestamp.IsZero() {
+			deleted = append(deleted, name)
+		}
+	}
+	if len(deleted) == 0 {
+		return nil
+	}
+
+	klog.Infof("namespace %v deleted, remove from clusterconfig %s/%s", deleted, clusterConfig.Namespace, clusterConfig.Name)
+	clusterConfig.Status.ProcessedNamespace = subtractStrings(clusterConfig.Status.ProcessedNamespace, deleted)
+	namespaces := make([]clusterconfigv1alpha1.NamespaceStatus, 0, len(clusterConfig.Status.Namespaces))
+	for _, status := range clusterConfig.Status.Namespaces {
+		if !containsString(deleted, status.Namespace) {
+			namespaces = append(namespaces, status)
+		}
+	}
+	clusterConfig.Status.Namespaces = namespaces
+	err := r.client.Status().Update(ctx, clusterConfig)
+	if err != nil {
+		return err
+	}
+
+	r.recordNamespaceEvents(clusterConfig, targetKind(clusterConfig), targetName(clusterConfig), namespaceEvents{EventReasonNamespaceRemoved: deleted})
+	return nil
+}
+
+// namespaceMatcher NamespaceList 或 ExcludeNamespaces 中的一项：
+// all、具体名称、glob（team-*）或以 / 包裹的正则（/^team-.*$/）
+type namespaceMatcher struct {
+	name  string
+	glob  bool
+	regex *regexp.Regexp
+}
+
+func parseNamespaceMatchers(items []string) ([]namespaceMatcher, error) {
+	matchers := make([]namespaceMatcher, 0, len(items))
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		switch {
+		case item == "":
+			continue
+		case len(item) > 2 && strings.HasPrefix(item, "/") && strings.HasSuffix(item, "/"):
+			re, err := regexp.Compile(item[1 : len(item)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid namespace regex %q: %w", item, err)
+			}
+			matchers = append(matchers, namespaceMatcher{name: item, regex: re})
+		case strings.ContainsAny(item, "*?["):
+			if _, err := path.Match(item, ""); err != nil {
+				return nil, fmt.Errorf("invalid namespace glob %q: %w", item, err)
+			}
+			matchers = append(matchers, namespaceMatcher{name: item, glob: true})
+		default:
+			matchers = append(matchers, namespaceMatcher{name: item})
+		}
+	}
+	return matchers, nil
+}
+
+func (m namespaceMatcher) match(namespace string) bool {
+	switch {
+	case m.regex != nil:
+		return m.regex.MatchString(namespace)
+	case m.glob:
+		ok, _ := path.Match(m.name, namespace)
+		return ok
+	default:
+		return m.name == allNamespace || m.name == namespace
+	}
+}
+
+// namespaceTarget ClusterConfig 的目标 namespace 规则
+type namespaceTarget struct {
+	include  []namespaceMatcher
+	exclude  []namespaceMatcher
+	selector labels.Selector
+}
+
+func newNamespaceTarget(clusterConfig *clusterconfigv1alpha1.ClusterConfig) (*namespaceTarget, error) {
+	names, err := namespaceNames(clusterConfig.Spec.NamespaceList)
+	if err != nil {
+		return nil, err
+	}
+	include, err := parseNamespaceMatchers(names)
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := parseNamespaceMatchers(clusterConfig.Spec.ExcludeNamespaces)
+	if err != nil {
+		return nil, err
+	}
+	selector, err := namespaceSelector(clusterConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &namespaceTarget{include: include, exclude: exclude, selector: selector}, nil
+}
+
+// matches 判断 namespace 是否命中目标，排除规则优先
+func (t *namespaceTarget) matches(namespace string, namespaceLabels map[string]string) bool {
+	if t.excluded(namespace) {
+		return false
+	}
+	for _, m := range t.include {
+		if m.match(namespace) {
+			return true
+		}
+	}
+	return t.selector != nil && t.selector.Matches(labels.Set(namespaceLabels))
+}
+
+func (t *namespaceTarget) excluded(namespace string) bool {
+	for _, m := range t.exclude {
+		if m.match(namespace) {
+			return true
+		}
+	}
+	return false
+}
+
+// namespaceNames 按逗号分割 NamespaceList 字段，去除空格与空字符串。
+// 以 / 开头的一项为正则，先按整体取出再分割，正则中可以包含逗号与空格（/^team-[a-z]{2,4}$/）：
+// 正则到其后紧跟逗号或字段结尾的 / 为止，缺少结尾的 / 时返回错误，避免被当作具体名称而匹配不到任何 namespace
+func namespaceNames(namespaceList string) ([]string, error) {
+	names := make([]string, 0)
+	rest := namespaceList
+	for {
+		rest = strings.TrimLeft(rest, " ")
+		if rest == "" {
+			return names, nil
+		}
+		var item string
+		if strings.HasPrefix(rest, "/") {
+			end := regexEnd(rest)
+			if end < 0 {
+				return nil, fmt.Errorf("invalid namespace regex %q: missing closing /", rest)
+			}
+			item = rest[:end+1]
+			rest = strings.TrimPrefix(strings.TrimLeft(rest[end+1:], " "), ",")
+		} else {
+			item, rest, _ = strings.Cut(rest, ",")
+			item = strings.ReplaceAll(item, " ", "")
+		}
+		if item != "" {
+			names = append(names, item)
+		}
+	}
+}
+
+// regexEnd 以 / 开头的正则结尾的 / 的位置，其后只能是空格、逗号或字段结尾，找不到时返回 -1
+func regexEnd(s string) int {
+	for i := 1; i < len(s); i++ {
+		if s[i] != '/' {
+			continue
+		}
+		rest := strings.TrimLeft(s[i+1:], " ")
+		if rest == "" || rest[0] == ',' {
+			return i
+		}
+	}
+	return -1
+}
+
+// namespaceSelector 将 NamespaceSelector 转为 labels.Selector，未设置时返回 nil
+func namespaceSelector(clusterConfig *clusterconfigv1alpha1.ClusterConfig) (labels.Selector, error) {
+	if clusterConfig.Spec.NamespaceSelector == nil {
+		return nil, nil
+	}
+	return metav1.LabelSelectorAsSelector(clusterConfig.Spec.NamespaceSelector)
+}
+
+// uniqueSorted 去重排序，并过滤掉 exclude 中的元素
+func uniqueSorted(input []string, exclude ...string) []string {
+	existenceMap := make(map[string]bool)
+	for _, item := range exclude {
+		existenceMap[item] = true
+	}
+
+	result := make([]string, 0, len(input))
+	for _, item := range input {
+		if !existenceMap[item] {
+			result = append(result, item)
+			existenceMap[item] = true
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// subtractStrings 返回 list 中不在 remove 中的元素
+func subtractStrings(list, remove []string) []string {
+	result := make([]string, 0, len(list))
+	for _, item := range list {
+		if !containsString(remove, item) {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// OnCreateNamespaceHandlerByClusterConfig namespace 创建时，把目标命中该 namespace 的 ClusterConfig 重新入列，
+// 使 ClusterConfig 之后创建的 namespace 也能及时下发配置
+func (r *ClusterConfigController) OnCreateNamespaceHandlerByClusterConfig(event event.CreateEvent, limitingInterface workqueue.RateLimitingInterface) {
+	namespace := event.Object
+	r.enqueueClusterConfigByNamespace(limitingInterface, namespace.GetName(), func(_ *clusterconfigv1alpha1.ClusterConfig, target *namespaceTarget) bool {
+		return target.matches(namespace.GetName(), namespace.GetLabels())
+	})
+}
+
+// OnUpdateNamespaceHandlerByClusterConfig namespace label 变更时，把变更前或变更后命中该 namespace 的 ClusterConfig 重新入列；
+// namespace 进入删除状态时，把记录了该 namespace 的 ClusterConfig 重新入列
+func (r *ClusterConfigController) OnUpdateNamespaceHandlerByClusterConfig(event event.UpdateEvent, limitingInterface workqueue.RateLimitingInterface) {
+	name := event.ObjectNew.GetName()
+	if event.ObjectOld.GetDeletionTimestamp() == nil && event.ObjectNew.GetDeletionTimestamp() != nil {
+		r.enqueueClusterConfigByNamespace(limitingInterface, name, func(clusterConfig *clusterconfigv1alpha1.ClusterConfig, _ *namespaceTarget) bool {
+			return recordsNamespace(clusterConfig, name)
+		})
+		return
+	}
+
+	oldLabels, newLabels := event.ObjectOld.GetLabels(), event.ObjectNew.GetLabels()
+	if reflect.DeepEqual(oldLabels, newLabels) {
+		return
+	}
+
+	r.enqueueClusterConfigByNamespace(limitingInterface, name, func(_ *clusterconfigv1alpha1.ClusterConfig, target *namespaceTarget) bool {
+		return target.matches(name, oldLabels) || target.matches(name, newLabels)
+	})
+}
+
+// OnDeleteNamespaceHandlerByClusterConfig namespace 删除时，把记录了该 namespace 的 ClusterConfig 重新入列
+func (r *ClusterConfigController) OnDeleteNamespaceHandlerByClusterConfig(event event.DeleteEvent, limitingInterface workqueue.RateLimitingInterface) {
+	name := event.Object.GetName()
+	r.enqueueClusterConfigByNamespace(limitingInterface, name, func(clusterConfig *clusterconfigv1alpha1.ClusterConfig, _ *namespaceTarget) bool {
+		return recordsNamespace(clusterConfig, name)
+	})
+}
+
+// recordsNamespace 判断 ClusterConfig 的 status 中是否记录了该 namespace
+func recordsNamespace(clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespace string) bool {
+	return containsString(clusterConfig.Status.ProcessedNamespace, namespace) || findNamespaceStatus(clusterConfig, namespace) != nil
+}
+
+// enqueueClusterConfigByNamespace 遍历所有 ClusterConfig，把满足 match 的重新入列
+func (r *ClusterConfigController) enqueueClusterConfigByNamespace(limitingInterface workqueue.RateLimitingInterface, namespace string, match func(clusterConfig *clusterconfigv1alpha1.ClusterConfig, target *namespaceTarget) bool) {
+	clusterConfigList := &clusterconfigv1alpha1.ClusterConfigList{}
+	err := r.client.List(context.Background(), clusterConfigList)
+	if err != nil {
+		klog.Error("list clusterconfig error: ", err)
+		return
+	}
+
+	for i := range clusterConfigList.Items {
+		clusterConfig := &clusterConfigList.Items[i]
+		target, err := newNamespaceTarget(clusterConfig)
+		if err != nil || !match(clusterConfig, target) {
+			continue
+		}
+		klog.Info("namespace changed: ", namespace, ", requeue clusterconfig: ", clusterConfig.Namespace+"/"+clusterConfig.Name)
+		limitingInterface.Add(reconcile.Request{
+			NamespacedName: types.NamespacedName{
+				Name: clusterConfig.Name, Namespace: clusterConfig.Namespace,
+			},
+		})
+	}
+}
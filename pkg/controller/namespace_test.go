@@ -0,0 +1,232 @@
+package controller
+
+import (
+	"context"
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"reflect"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sort"
+	"testing"
+)
+
+func TestNamespaceNames(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    []string
+		wantErr bool
+	}{
+		{input: "", want: []string{}},
+		{input: "default, test ,,", want: []string{"default", "test"}},
+		{input: "team-*,/^team-[a-z]{2,4}$/, default", want: []string{"team-*", "/^team-[a-z]{2,4}$/", "default"}},
+		{input: "/^(a|b), c$/", want: []string{"/^(a|b), c$/"}},
+		{input: "/^a/b$/ ,default", want: []string{"/^a/b$/", "default"}},
+		{input: "default,/^team-", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := namespaceNames(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: expected error %v, got %v", tt.input, tt.wantErr, err)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: expected %q, got %q", tt.input, tt.want, got)
+		}
+	}
+}
+
+func TestNamespaceTargetMatches(t *testing.T) {
+	teamSelector := &metav1.LabelSelector{MatchLabels: map[string]string{"team": "payments"}}
+	tests := []struct {
+		name      string
+		list      string
+		exclude   []string
+		selector  *metav1.LabelSelector
+		namespace string
+		labels    map[string]string
+		want      bool
+	}{
+		{name: "name", list: "default,test", namespace: "test", want: true},
+		{name: "name not listed", list: "default,test", namespace: "other", want: false},
+		{name: "all", list: "all", namespace: "anything", want: true},
+		{name: "glob", list: "team-*", namespace: "team-a", want: true},
+		{name: "glob not matched", list: "team-*", namespace: "teams", want: false},
+		{name: "regex with comma", list: "/^team-[a-z]{2,4}$/", namespace: "team-abc", want: true},
+		{name: "regex with comma not matched", list: "/^team-[a-z]{2,4}$/", namespace: "team-abcde", want: false},
+		{name: "exclude overrides name", list: "default,test", exclude: []string{"test"}, namespace: "test", want: false},
+		{name: "exclude glob overrides all", list: "all", exclude: []string{"kube-*"}, namespace: "kube-system", want: false},
+		{name: "exclude regex overrides all", list: "all", exclude: []string{"/^kube-/"}, namespace: "kube-public", want: false},
+		{name: "exclude keeps others", list: "all", exclude: []string{"kube-*"}, namespace: "default", want: true},
+		{name: "selector", selector: teamSelector, namespace: "billing", labels: map[string]string{"team": "payments"}, want: true},
+		{name: "selector not matched", selector: teamSelector, namespace: "billing", labels: map[string]string{"team": "search"}, want: false},
+		{name: "union list side", list: "default", selector: teamSelector, namespace: "default", want: true},
+		{name: "union selector side", list: "default", selector: teamSelector, namespace: "billing", labels: map[string]string{"team": "payments"}, want: true},
+		{name: "exclude overrides selector", selector: teamSelector, exclude: []string{"billing"}, namespace: "billing", labels: map[string]string{"team": "payments"}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cc := &clusterconfigv1alpha1.ClusterConfig{Spec: clusterconfigv1alpha1.ClusterConfigSpec{
+				NamespaceList:     tt.list,
+				ExcludeNamespaces: tt.exclude,
+				NamespaceSelector: tt.selector,
+			}}
+			target, err := newNamespaceTarget(cc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := target.matches(tt.namespace, tt.labels); got != tt.want {
+				t.Errorf("expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNewNamespaceTargetRejectsInvalidPatterns(t *testing.T) {
+	tests := []struct {
+		list    string
+		exclude []string
+	}{
+		{list: "/^team-[/"},
+		{list: "default,/^team-"},
+		{list: "team-["},
+		{exclude: []string{"/(/"}},
+	}
+	for _, tt := range tests {
+		cc := &clusterconfigv1alpha1.ClusterConfig{Spec: clusterconfigv1alpha1.ClusterConfigSpec{
+			NamespaceList:     tt.list,
+			ExcludeNamespaces: tt.exclude,
+		}}
+		if _, err := newNamespaceTarget(cc); err == nil {
+			t.Errorf("%q %q: expected error", tt.list, tt.exclude)
+		}
+	}
+}
+
+// newTargetingClusterConfig 按 namespaceList 与 namespaceSelector 选择 namespace 的 ClusterConfig
+func newTargetingClusterConfig(name, namespaceList string, matchLabels map[string]string) *clusterconfigv1alpha1.ClusterConfig {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	cc.Name, cc.UID = name, types.UID(name+"-uid")
+	cc.Spec.NamespaceList = namespaceList
+	if matchLabels != nil {
+		cc.Spec.NamespaceSelector = &metav1.LabelSelector{MatchLabels: matchLabels}
+	}
+	return cc
+}
+
+// queuedNames 取出队列中所有 ClusterConfig 的名称
+func queuedNames(queue workqueue.RateLimitingInterface) []string {
+	names := make([]string, 0)
+	for queue.Len() > 0 {
+		item, _ := queue.Get()
+		names = append(names, item.(reconcile.Request).Name)
+		queue.Done(item)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestNamespaceHandlersEnqueueMatchingClusterConfigs(t *testing.T) {
+	byGlob := newTargetingClusterConfig("by-glob", "team-*", nil)
+	byOldLabel := newTargetingClusterConfig("by-old-label", "", map[string]string{"team": "payments"})
+	byNewLabel := newTargetingClusterConfig("by-new-label", "", map[string]string{"team": "search"})
+	unrelated := newTargetingClusterConfig("unrelated", "default", map[string]string{"env": "prod"})
+	recorded := newTargetingClusterConfig("recorded", "default", nil)
+	recorded.Status.ProcessedNamespace = []string{"team-a"}
+	r, _ := newTestController(t, byGlob, byOldLabel, byNewLabel, unrelated, recorded)
+
+	old := &v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "team-a", Labels: map[string]string{"team": "payments"}}}
+	relabelled := old.DeepCopy()
+	relabelled.Labels["team"] = "search"
+	terminating := old.DeepCopy()
+	now := metav1.Now()
+	terminating.DeletionTimestamp = &now
+
+	tests := []struct {
+		name    string
+		trigger func(queue workqueue.RateLimitingInterface)
+		want    []string
+	}{
+		{
+			name: "create",
+			trigger: func(queue workqueue.RateLimitingInterface) {
+				r.OnCreateNamespaceHandlerByClusterConfig(event.CreateEvent{Object: old}, queue)
+			},
+			want: []string{"by-glob", "by-old-label"},
+		},
+		{
+			name: "relabel",
+			trigger: func(queue workqueue.RateLimitingInterface) {
+				r.OnUpdateNamespaceHandlerByClusterConfig(event.UpdateEvent{ObjectOld: old, ObjectNew: relabelled}, queue)
+			},
+			want: []string{"by-glob", "by-new-label", "by-old-label"},
+		},
+		{
+			name: "labels unchanged",
+			trigger: func(queue workqueue.RateLimitingInterface) {
+				r.OnUpdateNamespaceHandlerByClusterConfig(event.UpdateEvent{ObjectOld: old, ObjectNew: old.DeepCopy()}, queue)
+			},
+			want: []string{},
+		},
+		{
+			name: "terminating",
+			trigger: func(queue workqueue.RateLimitingInterface) {
+				r.OnUpdateNamespaceHandlerByClusterConfig(event.UpdateEvent{ObjectOld: old, ObjectNew: terminating}, queue)
+			},
+			want: []string{"recorded"},
+		},
+		{
+			name: "delete",
+			trigger: func(queue workqueue.RateLimitingInterface) {
+				r.OnDeleteNamespaceHandlerByClusterConfig(event.DeleteEvent{Object: old}, queue)
+			},
+			want: []string{"recorded"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+			defer queue.ShutDown()
+			tt.trigger(queue)
+			if got := queuedNames(queue); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected %v to be enqueued, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+// 已删除或正在删除的 namespace 从 status 中移除，并记录 NamespaceRemoved 事件
+func TestPruneDeletedNamespaces(t *testing.T) {
+	cc := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	cc.Status.ProcessedNamespace = []string{"ns1", "ns2", "ns3"}
+	resetNamespaceStatus(cc, []string{"ns1", "ns2", "ns3"})
+	now := metav1.Now()
+	r, c := newTestController(t, cc,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns2", DeletionTimestamp: &now, Finalizers: []string{"kubernetes"}}},
+	)
+
+	if err := r.pruneDeletedNamespaces(context.Background(), cc); err != nil {
+		t.Fatal(err)
+	}
+	stored := &clusterconfigv1alpha1.ClusterConfig{}
+	if err := c.Get(context.Background(), client.ObjectKeyFromObject(cc), stored); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored.Status.ProcessedNamespace, []string{"ns1"}) {
+		t.Errorf("expected processedNamespace [ns1], got %v", stored.Status.ProcessedNamespace)
+	}
+	if len(stored.Status.Namespaces) != 1 || stored.Status.Namespaces[0].Namespace != "ns1" {
+		t.Errorf("expected only ns1 in status.namespaces, got %+v", stored.Status.Namespaces)
+	}
+	events := drainEvents(r.EventRecorder.(*record.FakeRecorder))
+	want := []string{"Normal NamespaceRemoved ConfigMap test is no longer synced to namespaces ns2, ns3"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("expected events %q, got %q", want, events)
+	}
+}
@@ -0,0 +1,143 @@
+package controller
+
+import (
+	"fmt"
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"strings"
+)
+
+// errConflict 资源对象被其他 ClusterConfig 或用户占用
+var errConflict = fmt.Errorf("conflict")
+
+// isManagedBy 判断资源对象是否由该 ClusterConfig 管理
+func isManagedBy(obj metav1.Object, clusterConfig *clusterconfigv1alpha1.ClusterConfig) bool {
+	uid, ok := obj.GetLabels()[clusterconfigv1alpha1.LabelClusterConfigUID]
+	return ok && uid == string(clusterConfig.UID)
+}
+
+// isManaged 判断资源对象是否由某个 ClusterConfig 管理
+func isManaged(obj metav1.Object) bool {
+	_, ok := obj.GetLabels()[clusterconfigv1alpha1.LabelClusterConfigUID]
+	return ok
+}
+
+// ownerLabels 下发资源对象上标记所属 ClusterConfig 的 label
+func ownerLabels(clusterConfig *clusterconfigv1alpha1.ClusterConfig) map[string]string {
+	return map[string]string{
+		clusterconfigv1alpha1.LabelClusterConfigName:      clusterConfig.Name,
+		clusterconfigv1alpha1.LabelClusterConfigNamespace: clusterConfig.Namespace,
+		clusterconfigv1alpha1.LabelClusterConfigUID:       string(clusterConfig.UID),
+		clusterconfigv1alpha1.LabelManagedBy:              clusterconfigv1alpha1.ManagedByClusterConfig,
+	}
+}
+
+// validateOwnerLabels ClusterConfig 的名称写入 label value，最长 63 个字符，
+// 名称更长时写入任何 namespace 都会失败，调协开始时直接拒绝
+func validateOwnerLabels(clusterConfig *clusterconfigv1alpha1.ClusterConfig) error {
+	if errs := validation.IsValidLabelValue(clusterConfig.Name); len(errs) != 0 {
+		return fmt.Errorf("clusterconfig name %q can not be used as label %s: %s", clusterConfig.Name,
+			clusterconfigv1alpha1.LabelClusterConfigName, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// applyOwnerLabels 给资源对象打上所属 ClusterConfig 的 label
+func applyOwnerLabels(clusterConfig *clusterconfigv1alpha1.ClusterConfig, obj metav1.Object) {
+	obj.SetLabels(mergeStringMap(obj.GetLabels(), ownerLabels(clusterConfig)))
+}
+
+// checkAdoption 判断已存在的资源对象能否由该 ClusterConfig 写入，不能写入时返回 errConflict
+// contentMatches 代表资源对象的内容与期望一致
+func (r *ClusterConfigController) checkAdoption(clusterConfig *clusterconfigv1alpha1.ClusterConfig, obj metav1.Object, contentMatches bool) error {
+	if isManagedBy(obj, clusterConfig) {
+		return nil
+	}
+
+	labels := obj.GetLabels()
+	if isManaged(obj) {
+		// uid 不同但 name namespace 一致，代表同名 ClusterConfig 删除后重建，视为同一个 ClusterConfig
+		if labels[clusterconfigv1alpha1.LabelClusterConfigName] == clusterConfig.Name &&
+			labels[clusterconfigv1alpha1.LabelClusterConfigNamespace] == clusterConfig.Namespace {
+			return nil
+		}
+		// 其他 ClusterConfig 管理的资源对象不接管，避免互相覆盖
+		return fmt.Errorf("%w: %s/%s is managed by clusterconfig %s/%s", errConflict, obj.GetNamespace(), obj.GetName(),
+			labels[clusterconfigv1alpha1.LabelClusterConfigNamespace], labels[clusterconfigv1alpha1.LabelClusterConfigName])
+	}
+
+	policy := r.adoptionPolicy(clusterConfig)
+	if policy == clusterconfigv1alpha1.AdoptionPolicyAlways {
+		return nil
+	}
+
+	// 旧版本创建的资源对象没有 label，内容一致且 namespace 已记录在 status 中时直接接管，
+	// 旧版本使用 all 时 status 中只记录了 all
+	legacy := containsString(clusterConfig.Status.ProcessedNamespace, obj.GetNamespace()) ||
+		containsString(clusterConfig.Status.ProcessedNamespace, allNamespace)
+	if contentMatches && (legacy || policy == clusterconfigv1alpha1.AdoptionPolicyIfMatching) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: %s/%s already exists and is not managed by clusterconfig, adoptionPolicy is %s", errConflict, obj.GetNamespace(), obj.GetName(), policy)
+}
+
+func (r *ClusterConfigController) adoptionPolicy(clusterConfig *clusterconfigv1alpha1.ClusterConfig) clusterconfigv1alpha1.AdoptionPolicy {
+	if clusterConfig.Spec.AdoptionPolicy != "" {
+		return clusterConfig.Spec.AdoptionPolicy
+	}
+	if r.PolicyDefaults.AdoptionPolicy != "" {
+		return r.PolicyDefaults.AdoptionPolicy
+	}
+	return clusterconfigv1alpha1.AdoptionPolicyNever
+}
+
+// ensureFinalizer 添加 Finalizer，并移除旧版本以 namespace 命名的 Finalizer，返回是否有变更
+// pending 中的 namespace 仍有未接管的旧版本资源对象，保留对应的 Finalizer
+func ensureFinalizer(clusterConfig *clusterconfigv1alpha1.ClusterConfig, pending []string) bool {
+	changed := removeLegacyFinalizers(clusterConfig, pending)
+	if !controllerutil.ContainsFinalizer(clusterConfig, clusterconfigv1alpha1.ClusterConfigFinalizer) {
+		controllerutil.AddFinalizer(clusterConfig, clusterconfigv1alpha1.ClusterConfigFinalizer)
+		changed = true
+	}
+	return changed
+}
+
+// removeFinalizers 移除 Finalizer 与旧版本以 namespace 命名的 Finalizer，返回是否有变更
+func removeFinalizers(clusterConfig *clusterconfigv1alpha1.ClusterConfig) bool {
+	changed := removeLegacyFinalizers(clusterConfig, nil)
+	if controllerutil.ContainsFinalizer(clusterConfig, clusterconfigv1alpha1.ClusterConfigFinalizer) {
+		controllerutil.RemoveFinalizer(clusterConfig, clusterconfigv1alpha1.ClusterConfigFinalizer)
+		changed = true
+	}
+	return changed
+}
+
+// removeLegacyFinalizers 旧版本为每个 namespace（以及 all）添加一个以 namespace 命名的 Finalizer，
+// 现已改为按 label 追踪下发的资源对象。旧版本资源对象没有 label，只能通过 Finalizer 找到，
+// 因此 pending 中的 namespace 保留 Finalizer，直到资源对象被接管（打上 label）或按 deletionPolicy 处理
+func removeLegacyFinalizers(clusterConfig *clusterconfigv1alpha1.ClusterConfig, pending []string) bool {
+	changed := false
+	for _, finalizer := range legacyFinalizers(clusterConfig) {
+		if containsString(pending, finalizer) {
+			continue
+		}
+		controllerutil.RemoveFinalizer(clusterConfig, finalizer)
+		changed = true
+	}
+	return changed
+}
+
+// legacyFinalizers 以 namespace 命名的 Finalizer，跳过 k8s 内置与带域名的 Finalizer
+func legacyFinalizers(clusterConfig *clusterconfigv1alpha1.ClusterConfig) []string {
+	names := make([]string, 0)
+	for _, finalizer := range clusterConfig.Finalizers {
+		if finalizer == metav1.FinalizerOrphanDependents || finalizer == metav1.FinalizerDeleteDependents || strings.Contains(finalizer, "/") {
+			continue
+		}
+		names = append(names, finalizer)
+	}
+	return names
+}
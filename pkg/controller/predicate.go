@@ -0,0 +1,47 @@
+package controller
+
+import (
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+)
+
+// ClusterConfigPredicate 只处理 spec 变更（generation 变化）与开始删除，
+// 忽略 Reconcile 自身写入 status Finalizer 触发的更新事件
+func ClusterConfigPredicate() predicate.Predicate {
+	return predicate.Or(predicate.GenerationChangedPredicate{}, predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return e.ObjectOld.GetDeletionTimestamp().IsZero() && !e.ObjectNew.GetDeletionTimestamp().IsZero()
+		},
+	})
+}
+
+// ManagedCopyPredicate 只处理由 ClusterConfig 管理的 ConfigMap Secret，
+// 更新事件修改前后任意一方带有 label 即处理，以便发现 label 被手动移除
+func ManagedCopyPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool {
+			return isManaged(e.Object)
+		},
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			return isManaged(e.ObjectOld) || isManaged(e.ObjectNew)
+		},
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			return isManaged(e.Object)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return isManaged(e.Object)
+		},
+	}
+}
+
+// ManagedCopySelector 选出由 ClusterConfig 管理的资源对象，用于限制 manager cache 中的 ConfigMap Secret
+func ManagedCopySelector() labels.Selector {
+	requirement, err := labels.NewRequirement(clusterconfigv1alpha1.LabelClusterConfigUID, selection.Exists, nil)
+	if err != nil {
+		panic(err)
+	}
+	return labels.NewSelector().Add(*requirement)
+}
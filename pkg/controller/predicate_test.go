@@ -0,0 +1,55 @@
+package controller
+
+import (
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"testing"
+)
+
+func TestClusterConfigPredicate(t *testing.T) {
+	p := ClusterConfigPredicate()
+	old := newTestClusterConfig(clusterconfigv1alpha1.ClusterConfigFinalizer)
+	old.Generation = 1
+
+	statusOnly := old.DeepCopy()
+	statusOnly.Status.ObservedGeneration = 1
+	if p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: statusOnly}) {
+		t.Error("expected status update to be filtered")
+	}
+
+	specChanged := old.DeepCopy()
+	specChanged.Generation = 2
+	if !p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: specChanged}) {
+		t.Error("expected spec change to pass")
+	}
+
+	deleting := old.DeepCopy()
+	now := metav1.Now()
+	deleting.DeletionTimestamp = &now
+	if !p.Update(event.UpdateEvent{ObjectOld: old, ObjectNew: deleting}) {
+		t.Error("expected deletion to pass")
+	}
+}
+
+func TestManagedCopyPredicate(t *testing.T) {
+	p := ManagedCopyPredicate()
+	cc := newTestClusterConfig()
+	managed := newTestCopy(cc, "ns1", true)
+	unmanaged := newTestCopy(cc, "ns1", false)
+
+	if p.Create(event.CreateEvent{Object: unmanaged}) {
+		t.Error("expected unmanaged object to be filtered")
+	}
+	if !p.Delete(event.DeleteEvent{Object: managed}) {
+		t.Error("expected managed object to pass")
+	}
+	// label 被手动移除时仍然需要调协
+	if !p.Update(event.UpdateEvent{ObjectOld: managed, ObjectNew: unmanaged}) {
+		t.Error("expected label removal to pass")
+	}
+	if !ManagedCopySelector().Matches(labels.Set(managed.Labels)) || ManagedCopySelector().Matches(labels.Set(unmanaged.Labels)) {
+		t.Error("expected selector to match managed objects only")
+	}
+}
@@ -0,0 +1,179 @@
+package controller
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	stderrors "errors"
+	"fmt"
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	"github.com/myoperator/clusterconfigoperator/pkg/metrics"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"strings"
+	"time"
+)
+
+// Condition 的 Reason
+const (
+	ReasonSynced                = "Synced"
+	ReasonRetrying              = "Retrying"
+	ReasonTargetNamespaceFailed = "TargetNamespaceFailed"
+	ReasonPruneNamespaceFailed  = "PruneNamespaceFailed"
+	ReasonDeleteFailed          = "DeleteFailed"
+	ReasonFinalizerUpdateFailed = "FinalizerUpdateFailed"
+	ReasonSyncFailed            = "SyncFailed"
+	ReasonStatusUpdateFailed    = "StatusUpdateFailed"
+	ReasonInvalidName           = "InvalidName"
+	ReasonInvalidTargetName     = "InvalidTargetName"
+	ReasonInvalidPolicy         = "InvalidPolicy"
+	ReasonTargetNameConflict    = "TargetNameConflict"
+	ReasonObjectConflict        = "ObjectConflict"
+	ReasonNoConflict            = "NoConflict"
+	ReasonDriftDetected         = "DriftDetected"
+	ReasonNoDrift               = "NoDrift"
+)
+
+// setSyncedStatus 调协成功时设置 status
+func setSyncedStatus(clusterConfig *clusterconfigv1alpha1.ClusterConfig) {
+	now := metav1.Now()
+	clusterConfig.Status.ObservedGeneration = clusterConfig.Generation
+	clusterConfig.Status.LastSyncTime = &now
+	setCondition(clusterConfig, clusterconfigv1alpha1.ConditionReady, metav1.ConditionTrue, ReasonSynced, "all target namespaces are synced")
+	setCondition(clusterConfig, clusterconfigv1alpha1.ConditionProgressing, metav1.ConditionFalse, ReasonSynced, "")
+	setCondition(clusterConfig, clusterconfigv1alpha1.ConditionDegraded, metav1.ConditionFalse, ReasonSynced, "")
+	setConflictCondition(clusterConfig)
+	setDriftCondition(clusterConfig)
+}
+
+// setFailedStatus 调协失败时设置 status，LastSyncTime 保持上一次成功的时间
+func setFailedStatus(clusterConfig *clusterconfigv1alpha1.ClusterConfig, reason string, err error) {
+	clusterConfig.Status.ObservedGeneration = clusterConfig.Generation
+	setCondition(clusterConfig, clusterconfigv1alpha1.ConditionReady, metav1.ConditionFalse, reason, err.Error())
+	setCondition(clusterConfig, clusterconfigv1alpha1.ConditionProgressing, metav1.ConditionTrue, ReasonRetrying, "waiting for the next reconcile")
+	setCondition(clusterConfig, clusterconfigv1alpha1.ConditionDegraded, metav1.ConditionTrue, reason, err.Error())
+	setConflictCondition(clusterConfig)
+	setDriftCondition(clusterConfig)
+}
+
+// setConflictCondition 根据各 namespace 的同步状态设置 Conflict condition
+func setConflictCondition(clusterConfig *clusterconfigv1alpha1.ClusterConfig) {
+	namespaces := conflictedNamespaces(clusterConfig)
+	if len(namespaces) == 0 {
+		setCondition(clusterConfig, clusterconfigv1alpha1.ConditionConflict, metav1.ConditionFalse, ReasonNoConflict, "")
+		return
+	}
+	setCondition(clusterConfig, clusterconfigv1alpha1.ConditionConflict, metav1.ConditionTrue, ReasonObjectConflict,
+		fmt.Sprintf("target object is managed by others in namespace %s", strings.Join(namespaces, ",")))
+}
+
+// conflictedNamespaces 资源对象被占用而跳过的 namespace
+func conflictedNamespaces(clusterConfig *clusterconfigv1alpha1.ClusterConfig) []string {
+	return namespacesInPhase(clusterConfig, clusterconfigv1alpha1.NamespacePhaseConflict)
+}
+
+func namespacesInPhase(clusterConfig *clusterconfigv1alpha1.ClusterConfig, phase clusterconfigv1alpha1.NamespacePhase) []string {
+	namespaces := make([]string, 0)
+	for _, status := range clusterConfig.Status.Namespaces {
+		if status.Phase == phase {
+			namespaces = append(namespaces, status.Namespace)
+		}
+	}
+	return namespaces
+}
+
+// setDriftCondition 根据各 namespace 的同步状态设置 Drifted condition
+func setDriftCondition(clusterConfig *clusterconfigv1alpha1.ClusterConfig) {
+	namespaces := namespacesInPhase(clusterConfig, clusterconfigv1alpha1.NamespacePhaseDrifted)
+	if len(namespaces) == 0 {
+		setCondition(clusterConfig, clusterconfigv1alpha1.ConditionDrifted, metav1.ConditionFalse, ReasonNoDrift, "")
+		return
+	}
+	setCondition(clusterConfig, clusterconfigv1alpha1.ConditionDrifted, metav1.ConditionTrue, ReasonDriftDetected,
+		fmt.Sprintf("target object is modified manually in namespace %s", strings.Join(namespaces, ",")))
+}
+
+func setCondition(clusterConfig *clusterconfigv1alpha1.ClusterConfig, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	meta.SetStatusCondition(&clusterConfig.Status.Conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: clusterConfig.Generation,
+		Reason:             reason,
+		Message:            message,
+	})
+}
+
+// requeueWithError 记录失败原因到 status 后重新入列
+func (r *ClusterConfigController) requeueWithError(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig, reason string, err error) (reconcile.Result, error) {
+	setFailedStatus(clusterConfig, reason, err)
+	r.EventRecorder.Event(clusterConfig, v1.EventTypeWarning, reason, err.Error())
+	metrics.RecordStatus(clusterConfig)
+	statusCtx, cancel := statusContext(ctx)
+	defer cancel()
+	statusErr := r.client.Status().Update(statusCtx, clusterConfig)
+	if statusErr != nil && !errors.IsNotFound(statusErr) {
+		klog.Error("update clusterconfig status err: ", statusErr)
+	}
+	return reconcile.Result{Requeue: true, RequeueAfter: time.Second * 60}, err
+}
+
+// setNamespaceStatus 记录单个 namespace 的同步结果，err 为 nil 代表同步成功
+func setNamespaceStatus(clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespace, hash string, err error) {
+	status := findNamespaceStatus(clusterConfig, namespace)
+	if status == nil {
+		clusterConfig.Status.Namespaces = append(clusterConfig.Status.Namespaces, clusterconfigv1alpha1.NamespaceStatus{Namespace: namespace})
+		status = &clusterConfig.Status.Namespaces[len(clusterConfig.Status.Namespaces)-1]
+	}
+
+	if err != nil {
+		status.Phase = clusterconfigv1alpha1.NamespacePhaseFailed
+		if stderrors.Is(err, errConflict) {
+			status.Phase = clusterconfigv1alpha1.NamespacePhaseConflict
+		}
+		if stderrors.Is(err, errDrifted) {
+			status.Phase = clusterconfigv1alpha1.NamespacePhaseDrifted
+		}
+		status.LastError = err.Error()
+		return
+	}
+	now := metav1.Now()
+	status.Phase = clusterconfigv1alpha1.NamespacePhaseSynced
+	status.LastError = ""
+	status.ContentHash = hash
+	status.LastSyncTime = &now
+}
+
+// resetNamespaceStatus 按目标 namespace 列表整理 status：移除不再需要的 namespace，新增的 namespace 记为 Pending
+func resetNamespaceStatus(clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespaceList []string) {
+	namespaces := make([]clusterconfigv1alpha1.NamespaceStatus, 0, len(namespaceList))
+	for _, namespace := range namespaceList {
+		status := findNamespaceStatus(clusterConfig, namespace)
+		if status == nil {
+			status = &clusterconfigv1alpha1.NamespaceStatus{Namespace: namespace, Phase: clusterconfigv1alpha1.NamespacePhasePending}
+		}
+		namespaces = append(namespaces, *status)
+	}
+	clusterConfig.Status.Namespaces = namespaces
+}
+
+func findNamespaceStatus(clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespace string) *clusterconfigv1alpha1.NamespaceStatus {
+	for i := range clusterConfig.Status.Namespaces {
+		if clusterConfig.Status.Namespaces[i].Namespace == namespace {
+			return &clusterConfig.Status.Namespaces[i]
+		}
+	}
+	return nil
+}
+
+// contentHash 计算配置内容的 hash，json 序列化时 map 的 key 已排序
+func contentHash(data interface{}) string {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
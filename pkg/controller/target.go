@@ -0,0 +1,98 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sort"
+	"strings"
+)
+
+// targetName 下发资源对象的名称，TargetName 未设置时使用 ClusterConfig 的名称
+// TargetName 支持 ${name} ${namespace} 占位符，分别替换为 ClusterConfig 的名称与所在 namespace，
+// ex: ${name}-settings team-${namespace}-config
+func targetName(clusterConfig *clusterconfigv1alpha1.ClusterConfig) string {
+	if clusterConfig.Spec.TargetName == "" {
+		return clusterConfig.Name
+	}
+	replacer := strings.NewReplacer("${name}", clusterConfig.Name, "${namespace}", clusterConfig.Namespace)
+	return replacer.Replace(clusterConfig.Spec.TargetName)
+}
+
+// validateTargetName 校验替换占位符后的名称是否为合法的资源对象名称
+func validateTargetName(clusterConfig *clusterconfigv1alpha1.ClusterConfig) error {
+	name := targetName(clusterConfig)
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) != 0 {
+		return fmt.Errorf("invalid target name %q: %s", name, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+// targetConflicts 找出与其他 ClusterConfig 写入同一资源对象（相同类型、名称与 namespace）的 namespace，
+// 返回 namespace -> 占用该资源对象的 ClusterConfig。
+// 冲突时先创建的 ClusterConfig 生效，创建时间相同则按 namespace/name 排序靠前者生效
+func (r *ClusterConfigController) targetConflicts(ctx context.Context, clusterConfig *clusterconfigv1alpha1.ClusterConfig, namespaceList []string) (map[string]string, error) {
+	conflicts := make(map[string]string)
+
+	clusterConfigList := &clusterconfigv1alpha1.ClusterConfigList{}
+	err := r.client.List(ctx, clusterConfigList)
+	if err != nil {
+		return nil, err
+	}
+
+	name := targetName(clusterConfig)
+	for i := range clusterConfigList.Items {
+		other := &clusterConfigList.Items[i]
+		if other.UID == clusterConfig.UID || !other.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if other.Spec.ConfigType != clusterConfig.Spec.ConfigType || targetName(other) != name {
+			continue
+		}
+		if !takesPrecedence(other, clusterConfig) {
+			continue
+		}
+
+		otherNamespaceList, err := r.targetNamespaces(ctx, other)
+		if err != nil {
+			// 对方的配置有误时不会下发，不视为冲突
+			continue
+		}
+		for _, namespace := range otherNamespaceList {
+			if containsString(namespaceList, namespace) {
+				if _, ok := conflicts[namespace]; !ok {
+					conflicts[namespace] = other.Namespace + "/" + other.Name
+				}
+			}
+		}
+	}
+
+	return conflicts, nil
+}
+
+// takesPrecedence a 是否优先于 b 占用资源对象
+func takesPrecedence(a, b *clusterconfigv1alpha1.ClusterConfig) bool {
+	if !a.CreationTimestamp.Equal(&b.CreationTimestamp) {
+		return a.CreationTimestamp.Before(&b.CreationTimestamp)
+	}
+	return a.Namespace+"/"+a.Name < b.Namespace+"/"+b.Name
+}
+
+// targetConflictError 把冲突的 namespace 记为 Conflict，并汇总为一个错误
+func targetConflictError(clusterConfig *clusterconfigv1alpha1.ClusterConfig, conflicts map[string]string) error {
+	namespaces := make([]string, 0, len(conflicts))
+	for namespace := range conflicts {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+
+	errs := make([]error, 0, len(namespaces))
+	for _, namespace := range namespaces {
+		err := fmt.Errorf("%w: %s %s/%s is already managed by clusterconfig %s", errConflict, clusterConfig.Spec.ConfigType, namespace, targetName(clusterConfig), conflicts[namespace])
+		setNamespaceStatus(clusterConfig, namespace, "", err)
+		errs = append(errs, err)
+	}
+	return utilerrors.NewAggregate(errs)
+}
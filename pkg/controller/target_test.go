@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	"github.com/myoperator/clusterconfigoperator/pkg/common"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTargetName(t *testing.T) {
+	tests := []struct {
+		targetName string
+		want       string
+	}{
+		{targetName: "", want: "app"},
+		{targetName: "settings", want: "settings"},
+		{targetName: "${name}-settings", want: "app-settings"},
+		{targetName: "${namespace}-${name}", want: "team-a-app"},
+		{targetName: "${name}-${name}", want: "app-app"},
+	}
+	for _, tt := range tests {
+		cc := &clusterconfigv1alpha1.ClusterConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "team-a"},
+			Spec:       clusterconfigv1alpha1.ClusterConfigSpec{TargetName: tt.targetName},
+		}
+		if got := targetName(cc); got != tt.want {
+			t.Errorf("%q: expected %q, got %q", tt.targetName, tt.want, got)
+		}
+	}
+}
+
+func TestValidateTargetName(t *testing.T) {
+	tests := []struct {
+		targetName string
+		wantErr    bool
+	}{
+		{targetName: ""},
+		{targetName: "${namespace}.${name}"},
+		{targetName: "${name}_settings", wantErr: true},
+		{targetName: "Settings", wantErr: true},
+		{targetName: "${name}-", wantErr: true},
+		{targetName: "${unknown}", wantErr: true},
+		{targetName: strings.Repeat("a", 254), wantErr: true},
+	}
+	for _, tt := range tests {
+		cc := &clusterconfigv1alpha1.ClusterConfig{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "team-a"},
+			Spec:       clusterconfigv1alpha1.ClusterConfigSpec{TargetName: tt.targetName},
+		}
+		if err := validateTargetName(cc); (err != nil) != tt.wantErr {
+			t.Errorf("%q: expected error %v, got %v", tt.targetName, tt.wantErr, err)
+		}
+	}
+}
+
+func TestTakesPrecedence(t *testing.T) {
+	now := time.Now()
+	newClusterConfig := func(namespace, name string, created time.Time) *clusterconfigv1alpha1.ClusterConfig {
+		return &clusterconfigv1alpha1.ClusterConfig{ObjectMeta: metav1.ObjectMeta{
+			Name: name, Namespace: namespace, CreationTimestamp: metav1.NewTime(created),
+		}}
+	}
+	tests := []struct {
+		name string
+		a, b *clusterconfigv1alpha1.ClusterConfig
+		want bool
+	}{
+		{name: "older wins", a: newClusterConfig("default", "b", now), b: newClusterConfig("default", "a", now.Add(time.Second)), want: true},
+		{name: "newer loses", a: newClusterConfig("default", "a", now.Add(time.Second)), b: newClusterConfig("default", "b", now), want: false},
+		{name: "same time by name", a: newClusterConfig("default", "a", now), b: newClusterConfig("default", "b", now), want: true},
+		{name: "same time by namespace", a: newClusterConfig("team-b", "a", now), b: newClusterConfig("team-a", "b", now), want: false},
+	}
+	for _, tt := range tests {
+		if got := takesPrecedence(tt.a, tt.b); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+// 写入同一资源对象时先创建的 ClusterConfig 生效，类型不同、名称不同或正在删除的 ClusterConfig 不冲突
+func TestTargetConflicts(t *testing.T) {
+	now := time.Now()
+	newClusterConfig := func(name string, created time.Time, namespaceList string) *clusterconfigv1alpha1.ClusterConfig {
+		return &clusterconfigv1alpha1.ClusterConfig{
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name, Namespace: "default", UID: types.UID(name + "-uid"),
+				CreationTimestamp: metav1.NewTime(created), Finalizers: []string{clusterconfigv1alpha1.ClusterConfigFinalizer},
+			},
+			Spec: clusterconfigv1alpha1.ClusterConfigSpec{
+				ConfigType: common.ConfigMaps, TargetName: "shared", NamespaceList: namespaceList,
+			},
+		}
+	}
+	cc := newClusterConfig("self", now, "ns1,ns2,ns3")
+	older := newClusterConfig("older", now.Add(-time.Minute), "ns1")
+	newer := newClusterConfig("newer", now.Add(time.Minute), "ns2")
+	sameTime := newClusterConfig("aaa", now, "ns2")
+	otherType := newClusterConfig("secret", now.Add(-time.Minute), "ns3")
+	otherType.Spec.ConfigType = common.Secrets
+	otherName := newClusterConfig("renamed", now.Add(-time.Minute), "ns3")
+	otherName.Spec.TargetName = "other"
+	deleting := newClusterConfig("deleting", now.Add(-time.Minute), "ns3")
+	deletedAt := metav1.NewTime(now)
+	deleting.DeletionTimestamp = &deletedAt
+
+	r, _ := newTestController(t, cc, older, newer, sameTime, otherType, otherName, deleting,
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns1"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns2"}},
+		&v1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "ns3"}},
+	)
+
+	conflicts, err := r.targetConflicts(context.Background(), cc, []string{"ns1", "ns2", "ns3"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"ns1": "default/older", "ns2": "default/aaa"}
+	if !reflect.DeepEqual(conflicts, want) {
+		t.Errorf("expected %v, got %v", want, conflicts)
+	}
+}
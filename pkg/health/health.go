@@ -0,0 +1,57 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"time"
+)
+
+// checkTimeout 单次检查的超时时间，避免 probe 请求长时间阻塞
+const checkTimeout = 2 * time.Second
+
+// CacheSynced informer cache 已启动并完成首次同步
+func CacheSynced(c cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+		defer cancel()
+		if !c.WaitForCacheSync(ctx) {
+			return fmt.Errorf("informer cache not synced")
+		}
+		return nil
+	}
+}
+
+// LeaderElected 当前副本已成为 leader，未开启选主时 elected 在启动后直接关闭。
+// 不作为 /readyz 的检查项，否则备用副本永远不会就绪
+func LeaderElected(elected <-chan struct{}) healthz.Checker {
+	return func(_ *http.Request) error {
+		select {
+		case <-elected:
+			return nil
+		default:
+			return fmt.Errorf("not the leader")
+		}
+	}
+}
+
+// APIServerReachable 请求 apiserver 的 /readyz，apiserver 不可达时调协无法进行
+func APIServerReachable(config *rest.Config) (healthz.Checker, error) {
+	client, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), checkTimeout)
+		defer cancel()
+		err := client.RESTClient().Get().AbsPath("/readyz").Do(ctx).Error()
+		if err != nil {
+			return fmt.Errorf("kubernetes apiserver unreachable: %w", err)
+		}
+		return nil
+	}, nil
+}
@@ -0,0 +1,63 @@
+package health
+
+import (
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
+	"sigs.k8s.io/controller-runtime/pkg/cache/informertest"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"testing"
+)
+
+func TestCacheSynced(t *testing.T) {
+	synced := false
+	c := &informertest.FakeInformers{Synced: &synced}
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	if err := CacheSynced(c)(req); err == nil {
+		t.Error("expected error before the cache is synced")
+	}
+	synced = true
+	if err := CacheSynced(c)(req); err != nil {
+		t.Errorf("expected no error after the cache is synced, got %v", err)
+	}
+}
+
+func TestLeaderElected(t *testing.T) {
+	elected := make(chan struct{})
+	handler := healthz.CheckHandler{Checker: LeaderElected(elected)}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/leaderz", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("expected status %d before being elected, got %d", http.StatusInternalServerError, rec.Code)
+	}
+	close(elected)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/leaderz", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status %d after being elected, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestAPIServerReachable(t *testing.T) {
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/readyz" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	check, err := APIServerReachable(&rest.Config{Host: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	if err := check(req); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+	status = http.StatusServiceUnavailable
+	if err := check(req); err == nil {
+		t.Error("expected error when the apiserver is not ready")
+	}
+}
@@ -1,36 +1,59 @@
 package k8sconfig
 
 import (
-	"github.com/myoperator/clusterconfigoperator/pkg/common"
+	"flag"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/klog/v2"
-	"os"
+	"time"
 )
 
-// K8sRestConfig 集群外部使用
-func K8sRestConfig() *rest.Config {
-	// 读取配置
-	if os.Getenv("Release") == "1" {
-		klog.Info("run in the cluster")
-		return k8sRestConfigInPod()
-	}
+// Options 访问 apiserver 的客户端配置
+type Options struct {
+	// Kubeconfig kubeconfig 文件路径，为空时依次使用 $KUBECONFIG、~/.kube/config、in-cluster 配置
+	Kubeconfig string
+	// Context 使用 kubeconfig 中的指定 context，为空时使用 current-context
+	Context string
+	// QPS Burst 客户端限流
+	QPS   float64
+	Burst int
+	// Timeout 单个请求的超时时间，0 代表不超时
+	Timeout time.Duration
+}
 
-	path := common.GetWd()
-	config, err := clientcmd.BuildConfigFromFlags("", path+"/resources/config")
-	if err != nil {
-		klog.Fatal(err)
+// NewOptions 默认配置
+func NewOptions() *Options {
+	return &Options{
+		QPS:   50,
+		Burst: 100,
 	}
-	config.Insecure = true
-	klog.Info("run outside the cluster")
-	return config
 }
 
-// k8sRestConfigInPod 集群内部 Pod 里使用
-func k8sRestConfigInPod() *rest.Config {
-	config, err := rest.InClusterConfig()
+// AddFlags 注册客户端相关的启动参数
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	fs.StringVar(&o.Kubeconfig, "kubeconfig", o.Kubeconfig, "Path to a kubeconfig. Defaults to $KUBECONFIG, then ~/.kube/config, then the in-cluster config.")
+	fs.StringVar(&o.Context, "context", o.Context, "The kubeconfig context to use. Defaults to the current context.")
+	fs.Float64Var(&o.QPS, "kube-api-qps", o.QPS, "QPS to use while talking with the kubernetes apiserver.")
+	fs.IntVar(&o.Burst, "kube-api-burst", o.Burst, "Burst to use while talking with the kubernetes apiserver.")
+	fs.DurationVar(&o.Timeout, "kube-api-timeout", o.Timeout, "Timeout of a single request to the kubernetes apiserver, 0 means no timeout.")
+}
+
+// K8sRestConfig 按 client-go 的标准顺序加载配置：
+// --kubeconfig、$KUBECONFIG、~/.kube/config，都不存在时使用集群内部 Pod 的 in-cluster 配置。
+// 默认校验 apiserver 证书，需要跳过时在 kubeconfig 中显式设置 insecure-skip-tls-verify
+func K8sRestConfig(o *Options) (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = o.Kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: o.Context}
+
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+	config, err := clientConfig.ClientConfig()
 	if err != nil {
-		klog.Fatal(err)
+		return nil, err
 	}
-	return config
+	config.QPS = float32(o.QPS)
+	config.Burst = o.Burst
+	config.Timeout = o.Timeout
+	klog.Infof("connect to kubernetes apiserver %s", config.Host)
+	return config, nil
 }
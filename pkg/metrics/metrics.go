@@ -0,0 +1,130 @@
+package metrics
+
+import (
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	"sync"
+)
+
+// 指标的 namespace name 标签为 ClusterConfig，target_namespace 为下发的资源对象所在 namespace
+
+// DriftDetected 检测到下发的资源对象被手动修改或删除的次数，policy 为处理方式
+var DriftDetected = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "clusterconfig_drift_detected_total",
+	Help: "Number of times a propagated copy was found modified or deleted outside the operator.",
+}, []string{"namespace", "name", "target_namespace", "policy"})
+
+// DriftCorrected driftPolicy 为 Enforce 时恢复为期望内容的次数
+var DriftCorrected = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "clusterconfig_drift_corrected_total",
+	Help: "Number of times a drifted copy was reverted to the desired content.",
+}, []string{"namespace", "name", "target_namespace"})
+
+// SyncFailures 同步单个 namespace 失败的次数
+var SyncFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "clusterconfig_sync_failures_total",
+	Help: "Number of failed attempts to sync a copy into a target namespace.",
+}, []string{"namespace", "name", "target_namespace"})
+
+// PropagatedBytes 写入资源对象的 data 字节数（key 与 value 的长度之和），每次写入都会累加
+var PropagatedBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "clusterconfig_propagated_bytes_total",
+	Help: "Number of data bytes written to copies, counting keys and values.",
+}, []string{"namespace", "name"})
+
+// ManagedCopies 已下发的资源对象数量，包括被手动修改但按 driftPolicy 保留的资源对象
+var ManagedCopies = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "clusterconfig_managed_copies",
+	Help: "Number of copies currently managed by the ClusterConfig.",
+}, []string{"namespace", "name"})
+
+// NamespaceInSync namespace 中的资源对象是否与期望一致，1 为一致，0 为失败、冲突、漂移或尚未同步，
+// ex: 告警 clusterconfig_namespace_in_sync == 0 持续 5m
+var NamespaceInSync = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "clusterconfig_namespace_in_sync",
+	Help: "Whether the copy in the target namespace matches the desired content (1) or not (0).",
+}, []string{"namespace", "name", "target_namespace"})
+
+// LastSyncSuccess 最近一次同步成功的时间戳，未重新调协时不会更新，
+// ex: time() - clusterconfig_last_sync_success_timestamp_seconds
+var LastSyncSuccess = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "clusterconfig_last_sync_success_timestamp_seconds",
+	Help: "Unix time of the last successful sync into the target namespace.",
+}, []string{"namespace", "name", "target_namespace"})
+
+// NamespaceSyncDuration 同步单个 namespace 的耗时，已是最新而跳过的 namespace 不记录。
+// series 数量为目标 namespace 数量乘以 bucket 数量，bucket 取 10ms 到 10s 的 6 个
+var NamespaceSyncDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "clusterconfig_namespace_sync_duration_seconds",
+	Help:    "Time taken to sync a copy into a single target namespace.",
+	Buckets: prometheus.ExponentialBuckets(0.01, 4, 6),
+}, []string{"namespace", "name", "target_namespace"})
+
+func init() {
+	// 注册到 controller-runtime 的 Registry，由 manager 的 metrics server 暴露
+	metrics.Registry.MustRegister(DriftDetected, DriftCorrected, SyncFailures, PropagatedBytes, ManagedCopies,
+		NamespaceInSync, LastSyncSuccess, NamespaceSyncDuration)
+}
+
+var (
+	mu sync.Mutex
+	// recorded ClusterConfig -> 已记录指标的目标 namespace，用于移除不再是目标的 namespace
+	recorded = make(map[string]map[string]bool)
+)
+
+// RecordStatus 按 status.namespaces 记录各 namespace 的同步状态，
+// 只移除不再是目标的 namespace，其余 series 保持连续，避免告警的 for 计时被重置
+func RecordStatus(clusterConfig *clusterconfigv1alpha1.ClusterConfig) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := clusterConfig.Namespace + "/" + clusterConfig.Name
+	current := make(map[string]bool, len(clusterConfig.Status.Namespaces))
+	managed := 0
+	for _, status := range clusterConfig.Status.Namespaces {
+		current[status.Namespace] = true
+		inSync := 0.0
+		switch status.Phase {
+		case clusterconfigv1alpha1.NamespacePhaseSynced:
+			inSync = 1
+			managed++
+		case clusterconfigv1alpha1.NamespacePhaseDrifted:
+			managed++
+		}
+		NamespaceInSync.WithLabelValues(clusterConfig.Namespace, clusterConfig.Name, status.Namespace).Set(inSync)
+		if status.LastSyncTime != nil {
+			LastSyncSuccess.WithLabelValues(clusterConfig.Namespace, clusterConfig.Name, status.Namespace).Set(float64(status.LastSyncTime.Unix()))
+		}
+	}
+	for target := range recorded[key] {
+		if !current[target] {
+			deletePartialMatch(prometheus.Labels{"namespace": clusterConfig.Namespace, "name": clusterConfig.Name, "target_namespace": target})
+		}
+	}
+	recorded[key] = current
+	ManagedCopies.WithLabelValues(clusterConfig.Namespace, clusterConfig.Name).Set(float64(managed))
+}
+
+// DeleteClusterConfig ClusterConfig 删除后移除其所有指标
+func DeleteClusterConfig(namespace, name string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	delete(recorded, namespace+"/"+name)
+	deletePartialMatch(prometheus.Labels{"namespace": namespace, "name": name})
+}
+
+func deletePartialMatch(labels prometheus.Labels) {
+	DriftDetected.DeletePartialMatch(labels)
+	DriftCorrected.DeletePartialMatch(labels)
+	SyncFailures.DeletePartialMatch(labels)
+	NamespaceInSync.DeletePartialMatch(labels)
+	LastSyncSuccess.DeletePartialMatch(labels)
+	NamespaceSyncDuration.DeletePartialMatch(labels)
+	if _, ok := labels["target_namespace"]; ok {
+		return
+	}
+	PropagatedBytes.DeletePartialMatch(labels)
+	ManagedCopies.DeletePartialMatch(labels)
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func TestRecordStatus(t *testing.T) {
+	synced := metav1.NewTime(time.Unix(1700000000, 0))
+	cc := &clusterconfigv1alpha1.ClusterConfig{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"}}
+	cc.Status.Namespaces = []clusterconfigv1alpha1.NamespaceStatus{
+		{Namespace: "ns1", Phase: clusterconfigv1alpha1.NamespacePhaseSynced, LastSyncTime: &synced},
+		{Namespace: "ns2", Phase: clusterconfigv1alpha1.NamespacePhaseDrifted, LastSyncTime: &synced},
+		{Namespace: "ns3", Phase: clusterconfigv1alpha1.NamespacePhaseFailed},
+	}
+	RecordStatus(cc)
+	defer DeleteClusterConfig("default", "test")
+
+	if got := testutil.ToFloat64(ManagedCopies.WithLabelValues("default", "test")); got != 2 {
+		t.Errorf("expected 2 managed copies, got %v", got)
+	}
+	for target, want := range map[string]float64{"ns1": 1, "ns2": 0, "ns3": 0} {
+		if got := testutil.ToFloat64(NamespaceInSync.WithLabelValues("default", "test", target)); got != want {
+			t.Errorf("expected %s in sync %v, got %v", target, want, got)
+		}
+	}
+	if got := testutil.ToFloat64(LastSyncSuccess.WithLabelValues("default", "test", "ns1")); got != 1700000000 {
+		t.Errorf("expected last sync timestamp, got %v", got)
+	}
+
+	// 不再是目标的 namespace 被移除，其余保留
+	SyncFailures.WithLabelValues("default", "test", "ns3").Inc()
+	NamespaceSyncDuration.WithLabelValues("default", "test", "ns1").Observe(0.1)
+	NamespaceSyncDuration.WithLabelValues("default", "test", "ns3").Observe(0.1)
+	cc.Status.Namespaces = cc.Status.Namespaces[:2]
+	RecordStatus(cc)
+	if got := testutil.CollectAndCount(NamespaceInSync); got != 2 {
+		t.Errorf("expected 2 namespace series, got %d", got)
+	}
+	if got := testutil.CollectAndCount(SyncFailures); got != 0 {
+		t.Errorf("expected failures of removed namespace to be deleted, got %d series", got)
+	}
+	if got := testutil.CollectAndCount(NamespaceSyncDuration); got != 1 {
+		t.Errorf("expected sync duration of removed namespace to be deleted, got %d series", got)
+	}
+}
+
+func TestDeleteClusterConfig(t *testing.T) {
+	for _, name := range []string{"a", "b"} {
+		cc := &clusterconfigv1alpha1.ClusterConfig{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name}}
+		cc.Status.Namespaces = []clusterconfigv1alpha1.NamespaceStatus{{Namespace: "ns1", Phase: clusterconfigv1alpha1.NamespacePhaseSynced}}
+		RecordStatus(cc)
+		PropagatedBytes.WithLabelValues("default", name).Add(10)
+	}
+	DeleteClusterConfig("default", "a")
+	defer DeleteClusterConfig("default", "b")
+
+	for _, c := range []struct {
+		metric string
+		count  int
+	}{
+		{"namespace_in_sync", testutil.CollectAndCount(NamespaceInSync)},
+		{"managed_copies", testutil.CollectAndCount(ManagedCopies)},
+		{"propagated_bytes", testutil.CollectAndCount(PropagatedBytes)},
+	} {
+		if c.count != 1 {
+			t.Errorf("expected only the remaining ClusterConfig in %s, got %d series", c.metric, c.count)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package options
+
+import (
+	"flag"
+	"fmt"
+	"github.com/myoperator/clusterconfigoperator/pkg/controller"
+	"github.com/myoperator/clusterconfigoperator/pkg/k8sconfig"
+	"go.uber.org/zap/zapcore"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"os"
+	"sigs.k8s.io/yaml"
+	"time"
+)
+
+// Options manager 的启动配置，可以通过启动参数或 --config 指定的 yaml 文件设置，
+// 两者都设置时以启动参数为准
+type Options struct {
+	// ConfigFile yaml 配置文件路径
+	ConfigFile string `json:"-"`
+	// Client 访问 apiserver 的客户端配置，只能通过启动参数设置
+	Client *k8sconfig.Options `json:"-"`
+
+	// MetricsBindAddress metrics 的监听地址，为 0 时关闭
+	MetricsBindAddress string `json:"metricsBindAddress,omitempty"`
+	// HealthProbeBindAddress /healthz /readyz 的监听地址
+	HealthProbeBindAddress string `json:"healthProbeBindAddress,omitempty"`
+	// LeaderElection 选主配置
+	LeaderElection LeaderElection `json:"leaderElection,omitempty"`
+	// WatchNamespace 只处理该 namespace 下的 ClusterConfig，为空时处理所有 namespace
+	WatchNamespace string `json:"watchNamespace,omitempty"`
+	// SyncPeriod cache 全量 resync 的周期，为 0 时不 resync
+	SyncPeriod metav1.Duration `json:"syncPeriod,omitempty"`
+	// GracefulShutdownTimeout 收到 SIGTERM 后等待正在进行的调协结束的时间，调协在其中 2/3 的时间后取消，
+	// 需要小于 Pod 的 terminationGracePeriodSeconds
+	GracefulShutdownTimeout metav1.Duration `json:"gracefulShutdownTimeout,omitempty"`
+	// MaxConcurrentReconciles 同时调协的 ClusterConfig 数量
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+	// NamespaceWorkers 单个 ClusterConfig 并发同步 namespace 的 worker 数量
+	NamespaceWorkers int `json:"namespaceWorkers,omitempty"`
+	// Log 日志配置
+	Log Log `json:"log,omitempty"`
+	// Defaults ClusterConfig 未设置策略时使用的默认值
+	Defaults controller.PolicyDefaults `json:"defaults,omitempty"`
+}
+
+// LeaderElection 选主配置
+type LeaderElection struct {
+	// LeaderElect 是否开启选主
+	LeaderElect bool `json:"leaderElect,omitempty"`
+	// ResourceName Lease 的名称
+	ResourceName string `json:"resourceName,omitempty"`
+	// ResourceNamespace Lease 所在的 namespace，为空时使用 operator 所在 namespace
+	ResourceNamespace string `json:"resourceNamespace,omitempty"`
+}
+
+// Log 日志配置
+type Log struct {
+	// Level 日志级别：debug info warn error
+	Level string `json:"level,omitempty"`
+	// Format 日志格式：json console
+	Format string `json:"format,omitempty"`
+}
+
+// NewOptions 默认配置
+func NewOptions() *Options {
+	return &Options{
+		Client:                  k8sconfig.NewOptions(),
+		MetricsBindAddress:      ":8080",
+		HealthProbeBindAddress:  ":8081",
+		GracefulShutdownTimeout: metav1.Duration{Duration: 30 * time.Second},
+		MaxConcurrentReconciles: 1,
+		NamespaceWorkers:        controller.DefaultNamespaceWorkers,
+		LeaderElection: LeaderElection{
+			ResourceName: "clusterconfig-operator.api.practice.com",
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+	}
+}
+
+// AddFlags 注册启动参数
+func (o *Options) AddFlags(fs *flag.FlagSet) {
+	o.Client.AddFlags(fs)
+	fs.StringVar(&o.ConfigFile, "config", o.ConfigFile, "Path to a yaml config file. Command-line flags take precedence over the file.")
+	fs.StringVar(&o.MetricsBindAddress, "metrics-bind-address", o.MetricsBindAddress, "The address the metrics endpoint binds to, 0 disables it.")
+	fs.StringVar(&o.HealthProbeBindAddress, "health-probe-bind-address", o.HealthProbeBindAddress, "The address the health probe endpoint binds to.")
+	fs.BoolVar(&o.LeaderElection.LeaderElect, "leader-elect", o.LeaderElection.LeaderElect, "Enable leader election, ensuring only one replica reconciles at a time.")
+	fs.StringVar(&o.LeaderElection.ResourceName, "leader-election-id", o.LeaderElection.ResourceName, "Name of the lease used for leader election.")
+	fs.StringVar(&o.LeaderElection.ResourceNamespace, "leader-election-namespace", o.LeaderElection.ResourceNamespace, "Namespace of the leader election lease, defaults to the namespace the operator runs in.")
+	fs.StringVar(&o.WatchNamespace, "watch-namespace", o.WatchNamespace, "Only reconcile ClusterConfigs in this namespace, empty means all namespaces.")
+	fs.DurationVar(&o.SyncPeriod.Duration, "sync-period", o.SyncPeriod.Duration, "Period of the cache resync, 0 disables it.")
+	fs.DurationVar(&o.GracefulShutdownTimeout.Duration, "graceful-shutdown-timeout", o.GracefulShutdownTimeout.Duration, "Time to wait for in-flight reconciles to finish on shutdown, must be shorter than the pod's termination grace period.")
+	fs.IntVar(&o.MaxConcurrentReconciles, "max-concurrent-reconciles", o.MaxConcurrentReconciles, "Maximum number of ClusterConfigs reconciled concurrently.")
+	fs.IntVar(&o.NamespaceWorkers, "namespace-workers", o.NamespaceWorkers, "Number of namespaces synced concurrently for a single ClusterConfig.")
+	fs.StringVar(&o.Log.Level, "log-level", o.Log.Level, "Log level, one of debug, info, warn, error.")
+	fs.StringVar(&o.Log.Format, "log-format", o.Log.Format, "Log format, one of json, console.")
+	fs.StringVar((*string)(&o.Defaults.AdoptionPolicy), "default-adoption-policy", string(o.Defaults.AdoptionPolicy), "adoptionPolicy used when a ClusterConfig does not set one, defaults to Never.")
+	fs.StringVar((*string)(&o.Defaults.DeletionPolicy), "default-deletion-policy", string(o.Defaults.DeletionPolicy), "deletionPolicy used when a ClusterConfig does not set one, defaults to Delete.")
+	fs.StringVar((*string)(&o.Defaults.DriftPolicy), "default-drift-policy", string(o.Defaults.DriftPolicy), "driftPolicy used when a ClusterConfig does not set one, defaults to Enforce.")
+}
+
+// Parse 解析启动参数：先解析一次得到配置文件路径，读取配置文件后再解析一次，使启动参数覆盖配置文件
+func (o *Options) Parse(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if o.ConfigFile != "" {
+		b, err := os.ReadFile(o.ConfigFile)
+		if err != nil {
+			return fmt.Errorf("read config file: %w", err)
+		}
+		err = yaml.UnmarshalStrict(b, o)
+		if err != nil {
+			return fmt.Errorf("parse config file %s: %w", o.ConfigFile, err)
+		}
+		err = fs.Parse(args)
+		if err != nil {
+			return err
+		}
+	}
+	return o.Validate()
+}
+
+// Validate 校验配置
+func (o *Options) Validate() error {
+	if o.MaxConcurrentReconciles < 1 {
+		return fmt.Errorf("maxConcurrentReconciles must be at least 1, got %d", o.MaxConcurrentReconciles)
+	}
+	if o.NamespaceWorkers < 1 {
+		return fmt.Errorf("namespaceWorkers must be at least 1, got %d", o.NamespaceWorkers)
+	}
+	if o.SyncPeriod.Duration < 0 {
+		return fmt.Errorf("syncPeriod must not be negative, got %s", o.SyncPeriod.Duration)
+	}
+	if o.GracefulShutdownTimeout.Duration < 0 {
+		return fmt.Errorf("gracefulShutdownTimeout must not be negative, got %s", o.GracefulShutdownTimeout.Duration)
+	}
+	if _, err := o.Log.ZapLevel(); err != nil {
+		return err
+	}
+	if o.Log.Format != "json" && o.Log.Format != "console" {
+		return fmt.Errorf("log format must be json or console, got %q", o.Log.Format)
+	}
+	if err := o.Defaults.Validate(); err != nil {
+		return fmt.Errorf("invalid defaults: %w", err)
+	}
+	return nil
+}
+
+// DrainTimeout 收到 SIGTERM 后正在进行的调协继续执行的时间，取 GracefulShutdownTimeout 的 2/3，
+// 剩余时间用于取消后记录 status 与释放 Lease，避免等待超时导致进程以非 0 状态码退出
+func (o *Options) DrainTimeout() time.Duration {
+	return o.GracefulShutdownTimeout.Duration * 2 / 3
+}
+
+// ZapLevel 日志级别
+func (l Log) ZapLevel() (zapcore.Level, error) {
+	var level zapcore.Level
+	err := level.UnmarshalText([]byte(l.Level))
+	if err != nil {
+		return level, fmt.Errorf("invalid log level %q: %w", l.Level, err)
+	}
+	return level, nil
+}
@@ -0,0 +1,71 @@
+package options
+
+import (
+	"flag"
+	clusterconfigv1alpha1 "github.com/myoperator/clusterconfigoperator/pkg/apis/clusterconfig/v1alpha1"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func parse(t *testing.T, config string, args ...string) (*Options, error) {
+	t.Helper()
+	o := NewOptions()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	o.AddFlags(fs)
+	if config != "" {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		args = append([]string{"--config", path}, args...)
+	}
+	return o, o.Parse(fs, args)
+}
+
+// 启动参数覆盖配置文件，两者都未设置时使用默认值
+func TestParseFlagsOverrideConfigFile(t *testing.T) {
+	o, err := parse(t, `
+namespaceWorkers: 20
+syncPeriod: 10m
+watchNamespace: ops
+defaults:
+  driftPolicy: Warn
+`, "--namespace-workers=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.NamespaceWorkers != 5 {
+		t.Errorf("expected flag to win, got namespaceWorkers %d", o.NamespaceWorkers)
+	}
+	if o.SyncPeriod.Duration != 10*time.Minute || o.WatchNamespace != "ops" {
+		t.Errorf("expected values from config file, got syncPeriod %s watchNamespace %q", o.SyncPeriod.Duration, o.WatchNamespace)
+	}
+	if o.Defaults.DriftPolicy != clusterconfigv1alpha1.DriftPolicyWarn {
+		t.Errorf("expected default driftPolicy Warn, got %q", o.Defaults.DriftPolicy)
+	}
+	if o.MaxConcurrentReconciles != 1 || o.MetricsBindAddress != ":8080" {
+		t.Errorf("expected defaults to be kept, got %+v", o)
+	}
+}
+
+func TestParseRejectsInvalidOptions(t *testing.T) {
+	tests := map[string]struct {
+		config string
+		args   []string
+	}{
+		"unknown field":  {config: "namespaceWorker: 5\n"},
+		"workers":        {args: []string{"--namespace-workers=0"}},
+		"log level":      {args: []string{"--log-level=verbose"}},
+		"log format":     {config: "log:\n  format: text\n"},
+		"default policy": {args: []string{"--default-drift-policy=Revert"}},
+	}
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := parse(t, tt.config, tt.args...); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}